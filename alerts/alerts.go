@@ -0,0 +1,225 @@
+/*
+Threshold alerting on the computed stats.
+
+Every Interval we check each alert rule against every IP of the hosts it applies to. An alert starts
+out pending when the value goes past the fire threshold and only fires once it has stayed there for
+the rule's For duration. A firing alert is resolved when the value comes back past the clear threshold.
+All of the alert state is kept in memory; subscribers are told when an alert fires or resolves.
+
+Alerts for a host whose parent (or grandparent...) is down, or for an IP that is flapping, are
+suppressed. They still go through the same states but subscribers aren't told about them until the
+parent is back or the IP settles down; if the alert resolves while it is suppressed nobody ever
+hears about it.
+*/
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/stats"
+)
+
+const (
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+type Alert struct {
+	Rule         string    `json:"rule"`
+	Hostname     string    `json:"hostname"`
+	Ip           string    `json:"ip_address"`
+	Groups       []string  `json:"groups"`
+	Field        string    `json:"field"`
+	Op           string    `json:"op"`
+	Value        float64   `json:"value"`
+	Fire         float64   `json:"fire"`
+	Clear        float64   `json:"clear"`
+	State        string    `json:"state"`
+	ActiveSince  time.Time `json:"active_since"`
+	FiredAt      time.Time `json:"fired_at"`
+	ResolvedAt   time.Time `json:"resolved_at"`
+	Suppressed   bool      `json:"suppressed"`
+	SuppressedBy string    `json:"suppressed_by,omitempty"`
+	Notified     bool      `json:"notified"`
+	Message      string    `json:"message"`
+}
+
+var (
+	mu          sync.Mutex
+	active      map[string]*Alert
+	subscribers []func(Alert)
+	badFields   map[string]bool
+)
+
+func init() {
+	active = make(map[string]*Alert)
+	badFields = make(map[string]bool)
+}
+
+// Register a function to be called when an alert fires or resolves.
+func Subscribe(handler func(Alert)) {
+	mu.Lock()
+	defer mu.Unlock()
+	subscribers = append(subscribers, handler)
+}
+
+// Evaluate the rules every interval until ctx is cancelled.
+func Start(ctx context.Context, alertconf config.AlertConfiguration) {
+	slog.Info(fmt.Sprintf("Starting alert engine with %d rules", len(alertconf.Rules)))
+	ticker := time.NewTicker(alertconf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		Evaluate(alertconf.Rules, time.Now())
+	}
+}
+
+// Check all of the rules once and update the alert state.
+func Evaluate(rules []config.AlertRule, now time.Time) {
+	var notify []Alert
+	seen := make(map[string]bool)
+
+	mu.Lock()
+	for _, rule := range rules {
+		for host, rings := range stats.MonitoredHosts() {
+			if !rule.AppliesTo(host) {
+				continue
+			}
+			for index := 0; index < len(rings.Ips); index++ {
+				pIp := &rings.Ips[index]
+				value, ok := pIp.Value(rule.Field)
+				if !ok {
+					if !badFields[rule.Field] {
+						slog.Warn("Alert rule " + rule.Name + " uses unknown field: " + rule.Field)
+						badFields[rule.Field] = true
+					}
+					continue
+				}
+
+				key := rule.Name + "/" + host + "/" + pIp.Ip.String()
+				seen[key] = true
+				if alert, changed := evaluateAlert(rule, key, host, pIp.Ip.String(), value, now); changed {
+					notify = append(notify, alert)
+				}
+			}
+		}
+	}
+
+	// Anything we didn't see this time around has had its host removed
+	for key, alert := range active {
+		if seen[key] {
+			continue
+		}
+		if alert.State == StateFiring && alert.Notified {
+			alert.State = StateResolved
+			alert.ResolvedAt = now
+			alert.Message = alert.Rule + " " + alert.Hostname + " (" + alert.Ip + ") is no longer monitored"
+			notify = append(notify, *alert)
+		}
+		delete(active, key)
+	}
+	handlers := subscribers
+	mu.Unlock()
+
+	for _, alert := range notify {
+		slog.Info("Alert " + alert.State + ": " + alert.Message)
+		for _, handler := range handlers {
+			handler(alert)
+		}
+	}
+}
+
+/*
+Move a single alert through pending -> firing -> resolved. Returns the alert and true when
+subscribers need to be told it has fired or resolved. Must be called with the lock held.
+*/
+func evaluateAlert(rule config.AlertRule, key string, host string, ip string, value float64, now time.Time) (Alert, bool) {
+	breached := value > rule.Fire
+	recovered := value < rule.Clear
+	if rule.Op == "<" {
+		breached = value < rule.Fire
+		recovered = value > rule.Clear
+	}
+
+	alert, ok := active[key]
+	if !ok {
+		if !breached {
+			return Alert{}, false
+		}
+		alert = &Alert{
+			Rule:        rule.Name,
+			Hostname:    host,
+			Ip:          ip,
+			Groups:      config.GetHostGroups(host),
+			Field:       rule.Field,
+			Op:          rule.Op,
+			Fire:        rule.Fire,
+			Clear:       rule.Clear,
+			State:       StatePending,
+			ActiveSince: now,
+		}
+		active[key] = alert
+	}
+	alert.Value = value
+	alert.SuppressedBy, alert.Suppressed = stats.Suppressed(host)
+	if !alert.Suppressed && stats.IpFlapping(host, ip) {
+		alert.SuppressedBy, alert.Suppressed = "flapping", true
+	}
+
+	switch alert.State {
+	case StatePending:
+		if !breached {
+			delete(active, key)
+			return Alert{}, false
+		}
+		if now.Sub(alert.ActiveSince) >= rule.For {
+			alert.State = StateFiring
+			alert.FiredAt = now
+			alert.Message = fmt.Sprintf("%s %s (%s): %s is %g %s %g", rule.Name, host, ip, rule.Field, value, rule.Op, rule.Fire)
+		}
+	case StateFiring:
+		if recovered {
+			alert.State = StateResolved
+			alert.ResolvedAt = now
+			alert.Message = fmt.Sprintf("%s %s (%s): %s is back to %g", rule.Name, host, ip, rule.Field, value)
+			delete(active, key)
+			return *alert, alert.Notified
+		}
+	}
+
+	// Tell everyone about a firing alert once it isn't suppressed
+	if alert.State == StateFiring && !alert.Notified && !alert.Suppressed {
+		alert.Notified = true
+		return *alert, true
+	}
+	return Alert{}, false
+}
+
+// Return the current alerts sorted by when they became active, filtered by state if one is given.
+func List(state string) []Alert {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := []Alert{}
+	for _, alert := range active {
+		if state != "" && alert.State != state {
+			continue
+		}
+		list = append(list, *alert)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ActiveSince.Before(list[j].ActiveSince)
+	})
+	return list
+}
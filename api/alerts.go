@@ -0,0 +1,15 @@
+package api
+
+import (
+	"github.com/cheetahfox/longping/alerts"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Return the firing alerts, pass state=pending or state=all to see the pending alerts as well
+func GetAlerts(c *fiber.Ctx) error {
+	state := c.Query("state", alerts.StateFiring)
+	if state == "all" {
+		state = ""
+	}
+	return c.JSON(alerts.List(state))
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"github.com/cheetahfox/longping/events"
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+Return the event history newest first. The list can be filtered with the host, ip, type
+and state query parameters.
+*/
+func GetEvents(c *fiber.Ctx) error {
+	host := c.Query("host")
+	ip := c.Query("ip")
+	eventType := c.Query("type")
+	state := c.Query("state")
+
+	list := []events.Event{}
+	for _, e := range events.List() {
+		if host != "" && e.Hostname != host {
+			continue
+		}
+		if ip != "" && e.Ip != ip {
+			continue
+		}
+		if eventType != "" && e.Type != eventType {
+			continue
+		}
+		if state != "" && e.State != state {
+			continue
+		}
+		list = append(list, e)
+	}
+	return c.JSON(list)
+}
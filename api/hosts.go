@@ -0,0 +1,29 @@
+package api
+
+import (
+	"github.com/cheetahfox/longping/stats"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Return the current stats for all of the hosts we are monitoring
+func GetHosts(c *fiber.Ctx) error {
+	return c.JSON(stats.GetHostSummaries())
+}
+
+// Return the current stats for a single host
+func GetHost(c *fiber.Ctx) error {
+	host := c.Params("host")
+	if _, ok := stats.GetRingHost(host); !ok {
+		return fiber.NewError(fiber.StatusNotFound, "host not found: "+host)
+	}
+	return c.JSON(stats.GetHostSummary(host))
+}
+
+// Stop monitoring a host until the next restart, its IPs are retired from the exporters
+func DeleteHost(c *fiber.Ctx) error {
+	host := c.Params("host")
+	if err := stats.RemoveRingHost(host); err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
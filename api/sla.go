@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/cheetahfox/longping/stats"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Check the period query parameter, defaulting to monthly since that is what most SLAs use
+func slaPeriod(c *fiber.Ctx) (string, error) {
+	period := c.Query("period", stats.PeriodMonthly)
+	switch period {
+	case stats.PeriodHourly, stats.PeriodDaily, stats.PeriodMonthly:
+		return period, nil
+	}
+	return "", fiber.NewError(fiber.StatusBadRequest, "period must be hourly, daily or monthly")
+}
+
+// Return the SLA compliance report for every host
+func GetSla(c *fiber.Ctx) error {
+	period, err := slaPeriod(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(stats.GetAllSlaReports(period))
+}
+
+// Return the SLA compliance report for a single host
+func GetHostSla(c *fiber.Ctx) error {
+	host := c.Params("host")
+	if _, ok := stats.GetRingHost(host); !ok {
+		return fiber.NewError(fiber.StatusNotFound, "host not found: "+host)
+	}
+	period, err := slaPeriod(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(stats.GetSlaReports(host, period))
+}
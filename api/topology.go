@@ -0,0 +1,11 @@
+package api
+
+import (
+	"github.com/cheetahfox/longping/stats"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Return the parent/child tree of the hosts along with which ones are down or suppressed
+func GetTopology(c *fiber.Ctx) error {
+	return c.JSON(stats.GetTopology())
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+A threshold alert rule. The rule fires when Field has been past Fire (in the direction of Op) for
+For and only clears once it has come back past Clear, which gives us hysteresis so a value sitting
+right on the threshold doesn't flap the alert. Durations are stored in nanoseconds to match the
+*Ns stats fields. A rule with no Hosts or Groups applies to every host.
+*/
+type AlertRule struct {
+	Name   string
+	Field  string
+	Op     string
+	Fire   float64
+	Clear  float64
+	For    time.Duration
+	Hosts  []string
+	Groups []string
+}
+
+type AlertConfiguration struct {
+	Rules    []AlertRule
+	Interval time.Duration
+}
+
+/*
+Load the alert rules from ALERT_RULES. Rules are separated by ";" and each rule is written as:
+
+	name field op fire [clear value] [for duration] [host h1,h2] [group g1,g2]
+
+For example "highloss Packetloss100 > 0.02 clear 0.01 for 5m group core". Rules that don't parse
+are logged and skipped.
+*/
+func AlertEnvStartup() AlertConfiguration {
+	var alertconf AlertConfiguration
+
+	alertconf.Interval = 5 * time.Second
+	interval, err := strconv.Atoi(os.Getenv("ALERT_INTERVAL"))
+	if err == nil && interval > 0 {
+		alertconf.Interval = time.Duration(interval) * time.Second
+	}
+
+	for _, text := range strings.Split(os.Getenv("ALERT_RULES"), ";") {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		rule, err := parseAlertRule(text)
+		if err != nil {
+			log.Printf("Skipping alert rule %q: %s\n", text, err.Error())
+			continue
+		}
+		alertconf.Rules = append(alertconf.Rules, rule)
+	}
+
+	return alertconf
+}
+
+func parseAlertRule(text string) (AlertRule, error) {
+	var rule AlertRule
+
+	tokens := strings.Fields(text)
+	if len(tokens) < 4 {
+		return rule, fmt.Errorf("expected at least name field op value")
+	}
+	rule.Name = tokens[0]
+	rule.Field = tokens[1]
+	rule.Op = tokens[2]
+	if rule.Op != ">" && rule.Op != "<" {
+		return rule, fmt.Errorf("op must be > or <")
+	}
+
+	fire, err := parseAlertValue(tokens[3])
+	if err != nil {
+		return rule, err
+	}
+	rule.Fire = fire
+	rule.Clear = fire
+
+	options := tokens[4:]
+	if len(options)%2 != 0 {
+		return rule, fmt.Errorf("option %s is missing a value", options[len(options)-1])
+	}
+	for i := 0; i < len(options); i = i + 2 {
+		value := options[i+1]
+		switch options[i] {
+		case "clear":
+			rule.Clear, err = parseAlertValue(value)
+		case "for":
+			rule.For, err = time.ParseDuration(value)
+		case "host":
+			rule.Hosts = append(rule.Hosts, strings.Split(value, ",")...)
+		case "group":
+			rule.Groups = append(rule.Groups, strings.Split(value, ",")...)
+		default:
+			err = fmt.Errorf("unknown option %s", options[i])
+		}
+		if err != nil {
+			return rule, err
+		}
+	}
+
+	// The clear value has to be past the fire value in the direction the alert resolves or it never would
+	if rule.Op == ">" && rule.Clear > rule.Fire {
+		return rule, fmt.Errorf("clear %g is above fire %g, a > rule clears below its fire value", rule.Clear, rule.Fire)
+	}
+	if rule.Op == "<" && rule.Clear < rule.Fire {
+		return rule, fmt.Errorf("clear %g is below fire %g, a < rule clears above its fire value", rule.Clear, rule.Fire)
+	}
+
+	return rule, nil
+}
+
+// Values can be plain numbers or durations (50ms) which are turned into nanoseconds
+func parseAlertValue(value string) (float64, error) {
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", value)
+	}
+	return float64(duration), nil
+}
+
+// Check if the rule should be evaluated for a host
+func (rule AlertRule) AppliesTo(host string) bool {
+	if len(rule.Hosts) == 0 && len(rule.Groups) == 0 {
+		return true
+	}
+	for _, h := range rule.Hosts {
+		if h == host {
+			return true
+		}
+	}
+	for _, group := range GetHostGroups(host) {
+		for _, g := range rule.Groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package config
+
+import "testing"
+
+func TestParseAlertRuleClear(t *testing.T) {
+	tests := []struct {
+		text  string
+		valid bool
+	}{
+		{"highloss Packetloss100 > 0.02 clear 0.01", true},
+		{"highloss Packetloss100 > 0.02 clear 0.02", true},
+		{"highloss Packetloss100 > 0.02", true},
+		{"highloss Packetloss100 > 0.02 clear 0.05", false},
+		{"lowmos Mos100 < 3.5 clear 3.8", true},
+		{"lowmos Mos100 < 3.5 clear 3", false},
+		{"slow Avg1000LatencyNs > 50ms clear 40ms", true},
+		{"slow Avg1000LatencyNs > 50ms clear 60ms", false},
+	}
+	for _, test := range tests {
+		_, err := parseAlertRule(test.text)
+		if test.valid && err != nil {
+			t.Errorf("%q: unexpected error %s", test.text, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%q: expected an error", test.text)
+		}
+	}
+}
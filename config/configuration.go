@@ -4,6 +4,9 @@ import (
 	"log"
 
 	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,22 +18,70 @@ type Configuration struct {
 	FiberConfig   fiber.Config
 	LogLevel      string
 	InfluxEnabled bool
+	Exporters     []string
 	ProbeInterval int
 	ProbeTimeout  int
+	CodecDefault  string
+	CodecProfiles map[string]string
+	OutageLosses  int
+	EventHistory  int
+	FlapWindow    int
+	FlapThreshold int
+	FlapLosses    int
+	SlaDefault    float64
+	SlaTargets    map[string]float64
+	TimeWindows   map[string][]string
+	HostGroups    map[string][]string
+	HostParents   map[string]string
+
+	SnapshotFile     string
+	SnapshotInterval int
+	SnapshotMaxAge   int
+
+	ShutdownTimeout int
+
+	DnsRefreshInterval int
+
+	TargetLabels map[string]map[string]string
+	MetricsSplit bool
+	ApiRemove    bool
+
+	AnomalyThreshold float64
+	AnomalySustain   int
+	BaselineAlpha    float64
+	ChangeThreshold  float64
+	ChangeDrift      float64
 }
 
 type InfluxConfiguration struct {
+	Version        int
 	Bucket         string
 	InfluxMaxError int
 	InfluxdbServer string
 	Org            string
 	Token          string
+	Interval       time.Duration
+	Probes         bool
+	ProbeBucket    string
+	BufferDir      string
+	BufferMaxBytes int64
+
+	// InfluxDB 1.x
+	Database             string
+	RetentionPolicy      string
+	Username             string
+	Password             string
+	ProbeDatabase        string
+	ProbeRetentionPolicy string
 }
 
 var (
 	Config Configuration
 )
 
+// The codecs the VoIP estimate has impairment values for, the values themselves are in stats
+var Codecs = []string{"g711", "g729", "g723.1", "ilbc"}
+
 // Set configuration options from Env values and setup the Fiber options
 func Startup() error {
 	// Fiber Setup
@@ -50,6 +101,28 @@ func Startup() error {
 		Config.InfluxEnabled = true
 	}
 
+	/*
+		Exporters to send the stats to, Prometheus is always on unless EXPORTERS is set and
+		INFLUX_ENABLED is kept working by adding influx to the list. remote_write pushes the
+		Prometheus metrics so it needs the prometheus exporter keeping them up to date.
+	*/
+	Config.Exporters = nil
+	for _, name := range strings.Split(os.Getenv("EXPORTERS"), ",") {
+		if strings.TrimSpace(name) != "" {
+			Config.Exporters = append(Config.Exporters, strings.TrimSpace(name))
+		}
+	}
+	if len(Config.Exporters) == 0 {
+		Config.Exporters = []string{"prometheus"}
+	}
+	if Config.InfluxEnabled && !ExporterEnabled("influx") {
+		Config.Exporters = append(Config.Exporters, "influx")
+	}
+	Config.InfluxEnabled = ExporterEnabled("influx")
+	if ExporterEnabled("remote_write") && !ExporterEnabled("prometheus") {
+		Config.Exporters = append(Config.Exporters, "prometheus")
+	}
+
 	// Set the Probe Interval
 	probeInterval, err := strconv.Atoi(os.Getenv("PROBE_INTERVAL"))
 	if err != nil {
@@ -66,9 +139,304 @@ func Startup() error {
 		Config.ProbeTimeout = probeTimeout
 	}
 
+	// Number of packets in a row that have to be lost before we call it an outage
+	outageLosses, err := strconv.Atoi(os.Getenv("OUTAGE_LOSSES"))
+	if err != nil || outageLosses < 1 {
+		Config.OutageLosses = 5
+	} else {
+		Config.OutageLosses = outageLosses
+	}
+
+	// Number of events to keep in memory
+	eventHistory, err := strconv.Atoi(os.Getenv("EVENT_HISTORY"))
+	if err != nil || eventHistory < 1 {
+		Config.EventHistory = 1000
+	} else {
+		Config.EventHistory = eventHistory
+	}
+
+	// Flap detection, number of up/down changes within the window (in seconds)
+	flapWindow, err := strconv.Atoi(os.Getenv("FLAP_WINDOW"))
+	if err != nil || flapWindow < 1 {
+		Config.FlapWindow = 600
+	} else {
+		Config.FlapWindow = flapWindow
+	}
+	flapThreshold, err := strconv.Atoi(os.Getenv("FLAP_THRESHOLD"))
+	if err != nil || flapThreshold < 2 {
+		Config.FlapThreshold = 6
+	} else {
+		Config.FlapThreshold = flapThreshold
+	}
+	// Losses in a row that count as down for flap detection, a link can bounce without ever being in an outage
+	flapLosses, err := strconv.Atoi(os.Getenv("FLAP_LOSSES"))
+	if err != nil || flapLosses < 1 {
+		Config.FlapLosses = 2
+	} else {
+		Config.FlapLosses = flapLosses
+	}
+	Config.FlapLosses = min(Config.FlapLosses, Config.OutageLosses)
+
+	// Codec used for the VoIP quality estimate, set per host with "host=codec" pairs
+	Config.CodecDefault = "g711"
+	if codec := os.Getenv("CODEC_DEFAULT"); codec != "" {
+		if slices.Contains(Codecs, codec) {
+			Config.CodecDefault = codec
+		} else {
+			log.Printf("Unknown CODEC_DEFAULT %s, using g711\n", codec)
+		}
+	}
+	Config.CodecProfiles = make(map[string]string)
+	for host, codec := range parseHostMap(os.Getenv("CODEC_PROFILES")) {
+		if !slices.Contains(Codecs, codec) {
+			log.Printf("Skipping unknown codec for %s: %s\n", host, codec)
+			continue
+		}
+		Config.CodecProfiles[host] = codec
+	}
+
+	// SLA availability targets as a percentage, set per host with "host=99.95" pairs
+	slaDefault, err := strconv.ParseFloat(os.Getenv("SLA_DEFAULT"), 64)
+	if err != nil {
+		Config.SlaDefault = 99.9
+	} else {
+		Config.SlaDefault = slaDefault
+	}
+	Config.SlaTargets = make(map[string]float64)
+	for host, value := range parseHostMap(os.Getenv("SLA_TARGETS")) {
+		target, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Printf("Skipping invalid SLA target for %s: %s\n", host, value)
+			continue
+		}
+		Config.SlaTargets[host] = target
+	}
+
+	// Latency baseline and anomaly detection
+	anomalyThreshold, err := strconv.ParseFloat(os.Getenv("ANOMALY_THRESHOLD"), 64)
+	if err != nil || anomalyThreshold <= 0 {
+		Config.AnomalyThreshold = 3
+	} else {
+		Config.AnomalyThreshold = anomalyThreshold
+	}
+	anomalySustain, err := strconv.Atoi(os.Getenv("ANOMALY_SUSTAIN"))
+	if err != nil || anomalySustain < 0 {
+		Config.AnomalySustain = 300
+	} else {
+		Config.AnomalySustain = anomalySustain
+	}
+	baselineAlpha, err := strconv.ParseFloat(os.Getenv("BASELINE_ALPHA"), 64)
+	if err != nil || baselineAlpha <= 0 || baselineAlpha > 1 {
+		Config.BaselineAlpha = 0.2
+	} else {
+		Config.BaselineAlpha = baselineAlpha
+	}
+
+	// CUSUM change-point detection, both are in deviations of the series
+	changeThreshold, err := strconv.ParseFloat(os.Getenv("CHANGE_THRESHOLD"), 64)
+	if err != nil || changeThreshold <= 0 {
+		Config.ChangeThreshold = 8
+	} else {
+		Config.ChangeThreshold = changeThreshold
+	}
+	changeDrift, err := strconv.ParseFloat(os.Getenv("CHANGE_DRIFT"), 64)
+	if err != nil || changeDrift < 0 {
+		Config.ChangeDrift = 1
+	} else {
+		Config.ChangeDrift = changeDrift
+	}
+
+	// Time based windows as comma separated durations, set per host with "host=5m,1h" pairs
+	Config.TimeWindows = make(map[string][]string)
+	if os.Getenv("TIME_WINDOWS_DEFAULT") != "" {
+		Config.TimeWindows[""] = parseWindows(os.Getenv("TIME_WINDOWS_DEFAULT"))
+	}
+	for host, value := range parseHostMap(os.Getenv("TIME_WINDOWS")) {
+		Config.TimeWindows[host] = parseWindows(value)
+	}
+
+	// Groups of hosts as "group=host1,host2" pairs
+	Config.HostGroups = make(map[string][]string)
+	for group, value := range parseHostMap(os.Getenv("HOST_GROUPS")) {
+		Config.HostGroups[group] = strings.Split(value, ",")
+	}
+
+	// Ring snapshots are only saved if we have somewhere to put them
+	Config.SnapshotFile = os.Getenv("SNAPSHOT_FILE")
+	snapshotInterval, err := strconv.Atoi(os.Getenv("SNAPSHOT_INTERVAL"))
+	if err != nil || snapshotInterval < 1 {
+		Config.SnapshotInterval = 300
+	} else {
+		Config.SnapshotInterval = snapshotInterval
+	}
+	snapshotMaxAge, err := strconv.Atoi(os.Getenv("SNAPSHOT_MAX_AGE"))
+	if err != nil || snapshotMaxAge < 0 {
+		Config.SnapshotMaxAge = 3600
+	} else {
+		Config.SnapshotMaxAge = snapshotMaxAge
+	}
+
+	// How long we wait for everything to stop and flush before giving up on shutdown
+	shutdownTimeout, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || shutdownTimeout < 1 {
+		Config.ShutdownTimeout = 10
+	} else {
+		Config.ShutdownTimeout = shutdownTimeout
+	}
+
+	// Hosts are only resolved at startup unless we are told to check them again
+	dnsRefresh, err := strconv.Atoi(os.Getenv("DNS_REFRESH_INTERVAL"))
+	if err == nil && dnsRefresh > 0 {
+		Config.DnsRefreshInterval = dnsRefresh
+	} else {
+		Config.DnsRefreshInterval = 0
+	}
+
+	// Parent of each host as "child=parent" pairs
+	Config.HostParents = parseHostMap(os.Getenv("HOST_PARENTS"))
+
+	// Static labels added to the Prometheus metrics of a host as "host=site:nyc,provider:zayo" pairs
+	Config.TargetLabels = make(map[string]map[string]string)
+	for host, value := range parseHostMap(os.Getenv("TARGET_LABELS")) {
+		Config.TargetLabels[host] = parseTargetLabels(host, value)
+	}
+
+	// Serve the probe and self metrics on their own endpoints as well as /metrics
+	if os.Getenv("METRICS_SPLIT") == "true" {
+		Config.MetricsSplit = true
+	}
+
+	// Anyone who can reach the API could stop monitoring a host so removing them is opt in
+	if os.Getenv("API_ALLOW_REMOVE") == "true" {
+		Config.ApiRemove = true
+	}
+
 	return nil
 }
 
+// Return the parent of a host if it has one
+func GetParent(host string) (string, bool) {
+	parent, ok := Config.HostParents[host]
+	if !ok || parent == "" || parent == host {
+		return "", false
+	}
+	return parent, true
+}
+
+// Return the names of the groups a host belongs to sorted by name
+func GetHostGroups(host string) []string {
+	var groups []string
+	for group, hosts := range Config.HostGroups {
+		for _, h := range hosts {
+			if h == host {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Return the time windows for a host falling back to the default windows
+func GetTimeWindows(host string) []string {
+	if windows, ok := Config.TimeWindows[host]; ok {
+		return windows
+	}
+	return Config.TimeWindows[""]
+}
+
+// Parse a comma separated list of durations skipping any we can't parse
+func parseWindows(env string) []string {
+	var windows []string
+	for _, window := range strings.Split(env, ",") {
+		window = strings.TrimSpace(window)
+		if window == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(window); err != nil {
+			log.Printf("Skipping invalid time window %s\n", window)
+			continue
+		}
+		windows = append(windows, window)
+	}
+	return windows
+}
+
+// Return the codec profile name for a host falling back to the default codec
+func GetCodecProfile(host string) string {
+	if codec, ok := Config.CodecProfiles[host]; ok {
+		return codec
+	}
+	return Config.CodecDefault
+}
+
+// Return the SLA target for a host falling back to the default target
+func GetSlaTarget(host string) float64 {
+	if target, ok := Config.SlaTargets[host]; ok {
+		return target
+	}
+	return Config.SlaDefault
+}
+
+// Check if an exporter is in the EXPORTERS list
+func ExporterEnabled(name string) bool {
+	for _, exporter := range Config.Exporters {
+		if exporter == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Labels we already use, a static label can't replace them
+var reservedLabels = map[string]bool{
+	"hostname":   true,
+	"ip_address": true,
+	"ip_version": true,
+	"window":     true,
+	"le":         true,
+	"quantile":   true,
+}
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Parse the comma separated "name:value" labels of a host
+func parseTargetLabels(host string, value string) map[string]string {
+	labels := make(map[string]string)
+	for _, label := range strings.Split(value, ",") {
+		name, labelValue, found := strings.Cut(label, ":")
+		if !found || !labelName.MatchString(name) || strings.HasPrefix(name, "__") || reservedLabels[name] {
+			log.Printf("Skipping invalid label for %s: %s\n", host, label)
+			continue
+		}
+		labels[name] = labelValue
+	}
+	return labels
+}
+
+// Return the static labels of a host, nil if it doesn't have any
+func GetTargetLabels(host string) map[string]string {
+	return Config.TargetLabels[host]
+}
+
+/*
+Parse a space separated list of "host=value" pairs into a map. Entries without a "="
+are skipped since we can't tell what they are supposed to be set to.
+*/
+func parseHostMap(env string) map[string]string {
+	hostMap := make(map[string]string)
+	for _, field := range strings.Fields(env) {
+		host, value, found := strings.Cut(field, "=")
+		if !found || host == "" {
+			log.Printf("Skipping invalid host setting %s\n", field)
+			continue
+		}
+		hostMap[host] = value
+	}
+	return hostMap
+}
+
 // Get Hosts from Env and return them as a slice
 func GetHosts() []string {
 
@@ -86,6 +454,11 @@ func GetHosts() []string {
 func InfluxEnvStartup() InfluxConfiguration {
 	var influxconf InfluxConfiguration
 
+	influxconf.Version = 2
+	if os.Getenv("INFLUX_VERSION") == "1" {
+		influxconf.Version = 1
+	}
+
 	requiredEnvVars := []string{
 		"INFLUX_SERVER", // Influxdb server url including port number
 		"INFLUX_TOKEN",  // Influx Token
@@ -93,6 +466,14 @@ func InfluxEnvStartup() InfluxConfiguration {
 		"INFLUX_ORG",    // Influx ord
 		"DB_MAX_ERROR",
 	}
+	// 1.x servers don't have orgs or tokens, just a database and optionally a user
+	if influxconf.Version == 1 {
+		requiredEnvVars = []string{
+			"INFLUX_SERVER",
+			"INFLUX_DATABASE",
+			"DB_MAX_ERROR",
+		}
+	}
 
 	// Check if the Required Enviromental varibles are set exit if they aren't.
 	for index := range requiredEnvVars {
@@ -103,7 +484,7 @@ func InfluxEnvStartup() InfluxConfiguration {
 
 	influxconf.InfluxMaxError = 10
 	influxerrors, err := strconv.Atoi(os.Getenv("DB_MAX_ERROR"))
-	if err != nil {
+	if err == nil {
 		influxconf.InfluxMaxError = influxerrors
 	}
 
@@ -113,5 +494,40 @@ func InfluxEnvStartup() InfluxConfiguration {
 	influxconf.Org = os.Getenv("INFLUX_ORG")
 	influxconf.InfluxdbServer = os.Getenv("INFLUX_SERVER")
 
+	influxconf.Database = os.Getenv("INFLUX_DATABASE")
+	influxconf.RetentionPolicy = os.Getenv("INFLUX_RETENTION_POLICY")
+	influxconf.Username = os.Getenv("INFLUX_USERNAME")
+	influxconf.Password = os.Getenv("INFLUX_PASSWORD")
+
+	// Every probe can also be written on its own, to a different bucket if it should be kept for less time
+	influxconf.Probes = os.Getenv("INFLUX_PROBES") == "true"
+	influxconf.ProbeBucket = os.Getenv("INFLUX_PROBE_BUCKET")
+	if influxconf.ProbeBucket == "" {
+		influxconf.ProbeBucket = influxconf.Bucket
+	}
+	influxconf.ProbeDatabase = os.Getenv("INFLUX_PROBE_DATABASE")
+	influxconf.ProbeRetentionPolicy = os.Getenv("INFLUX_PROBE_RETENTION_POLICY")
+	if influxconf.ProbeDatabase == "" {
+		influxconf.ProbeDatabase = influxconf.Database
+		if influxconf.ProbeRetentionPolicy == "" {
+			influxconf.ProbeRetentionPolicy = influxconf.RetentionPolicy
+		}
+	}
+
+	// Points that fail to write are kept on disk until the database is back
+	influxconf.BufferDir = os.Getenv("INFLUX_BUFFER_DIR")
+	influxconf.BufferMaxBytes = 100 * 1024 * 1024
+	bufferMax, err := strconv.Atoi(os.Getenv("INFLUX_BUFFER_MAX_MB"))
+	if err == nil && bufferMax > 0 {
+		influxconf.BufferMaxBytes = int64(bufferMax) * 1024 * 1024
+	}
+
+	// How often the windows are written
+	influxconf.Interval = 15 * time.Second
+	interval, err := strconv.Atoi(os.Getenv("INFLUX_INTERVAL"))
+	if err == nil && interval > 0 {
+		influxconf.Interval = time.Duration(interval) * time.Second
+	}
+
 	return influxconf
 }
@@ -0,0 +1,21 @@
+package config
+
+import "testing"
+
+func TestCodecValidation(t *testing.T) {
+	t.Setenv("HOSTS", "router1")
+	t.Setenv("CODEC_DEFAULT", "g7111")
+	t.Setenv("CODEC_PROFILES", "router1=g729 router2=opus")
+	if err := Startup(); err != nil {
+		t.Fatal(err)
+	}
+	if Config.CodecDefault != "g711" {
+		t.Errorf("unknown default codec kept as %s", Config.CodecDefault)
+	}
+	if GetCodecProfile("router1") != "g729" {
+		t.Errorf("router1 codec is %s, want g729", GetCodecProfile("router1"))
+	}
+	if GetCodecProfile("router2") != "g711" {
+		t.Errorf("router2 with an unknown codec is %s, want the default", GetCodecProfile("router2"))
+	}
+}
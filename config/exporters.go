@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type OtlpConfiguration struct {
+	Endpoint string
+	Protocol string
+	Insecure bool
+	Interval time.Duration
+}
+
+/*
+Load the OTLP exporter settings. OTLP_PROTOCOL is grpc (the default) or http and OTLP_ENDPOINT is the
+host:port of the collector. When OTLP_ENDPOINT isn't set the standard OTEL_EXPORTER_OTLP_* variables
+are used, which default to a collector on localhost.
+*/
+func OtlpEnvStartup() OtlpConfiguration {
+	var otlpconf OtlpConfiguration
+
+	otlpconf.Endpoint = os.Getenv("OTLP_ENDPOINT")
+	otlpconf.Protocol = "grpc"
+	if os.Getenv("OTLP_PROTOCOL") == "http" {
+		otlpconf.Protocol = "http"
+	}
+	otlpconf.Insecure = os.Getenv("OTLP_INSECURE") == "true"
+
+	otlpconf.Interval = 15 * time.Second
+	interval, err := strconv.Atoi(os.Getenv("OTLP_INTERVAL"))
+	if err == nil && interval > 0 {
+		otlpconf.Interval = time.Duration(interval) * time.Second
+	}
+
+	return otlpconf
+}
+
+type RemoteWriteConfiguration struct {
+	Url         string
+	Username    string
+	Password    string
+	BearerToken string
+	Interval    time.Duration
+	Timeout     time.Duration
+	Labels      map[string]string
+	WalDir      string
+	WalMaxBytes int64
+}
+
+/*
+Load the remote write settings. REMOTE_WRITE_URL is required, REMOTE_WRITE_LABELS is a comma
+separated list of name=value labels added to every series so the edge sites can be told apart.
+Without REMOTE_WRITE_WAL_DIR the queue is only kept in memory.
+*/
+func RemoteWriteEnvStartup() (RemoteWriteConfiguration, error) {
+	var rwconf RemoteWriteConfiguration
+
+	rwconf.Url = os.Getenv("REMOTE_WRITE_URL")
+	if rwconf.Url == "" {
+		return rwconf, errors.New("REMOTE_WRITE_URL is required for the remote_write exporter")
+	}
+	rwconf.Username = os.Getenv("REMOTE_WRITE_USERNAME")
+	rwconf.Password = os.Getenv("REMOTE_WRITE_PASSWORD")
+	rwconf.BearerToken = os.Getenv("REMOTE_WRITE_BEARER_TOKEN")
+
+	rwconf.Interval = 15 * time.Second
+	interval, err := strconv.Atoi(os.Getenv("REMOTE_WRITE_INTERVAL"))
+	if err == nil && interval > 0 {
+		rwconf.Interval = time.Duration(interval) * time.Second
+	}
+
+	rwconf.Timeout = 30 * time.Second
+	timeout, err := strconv.Atoi(os.Getenv("REMOTE_WRITE_TIMEOUT"))
+	if err == nil && timeout > 0 {
+		rwconf.Timeout = time.Duration(timeout) * time.Second
+	}
+
+	rwconf.Labels = make(map[string]string)
+	for _, label := range strings.Split(os.Getenv("REMOTE_WRITE_LABELS"), ",") {
+		if strings.TrimSpace(label) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(label, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			return rwconf, fmt.Errorf("invalid remote write label %q, expected name=value", label)
+		}
+		rwconf.Labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	rwconf.WalDir = os.Getenv("REMOTE_WRITE_WAL_DIR")
+	rwconf.WalMaxBytes = 100 * 1024 * 1024
+	walMax, err := strconv.Atoi(os.Getenv("REMOTE_WRITE_WAL_MAX_MB"))
+	if err == nil && walMax > 0 {
+		rwconf.WalMaxBytes = int64(walMax) * 1024 * 1024
+	}
+
+	return rwconf, nil
+}
+
+type PrometheusConfiguration struct {
+	Legacy   bool
+	Longping bool
+}
+
+/*
+Pick which metric names the prometheus exporter serves. PROMETHEUS_METRICS is legacy (the default,
+the original names like avg_100_latency_ns), longping (longping_ prefixed metrics in seconds with a
+window label) or both while dashboards are moved over.
+*/
+func PrometheusEnvStartup() PrometheusConfiguration {
+	var promconf PrometheusConfiguration
+
+	switch os.Getenv("PROMETHEUS_METRICS") {
+	case "longping":
+		promconf.Longping = true
+	case "both":
+		promconf.Legacy = true
+		promconf.Longping = true
+	default:
+		promconf.Legacy = true
+	}
+
+	return promconf
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type WebhookConfiguration struct {
+	Urls       []string
+	Template   string
+	EventTypes []string
+	Retries    int
+	Timeout    time.Duration
+}
+
+/*
+Load the webhook settings. WEBHOOK_TEMPLATE is a Go text/template for the body, if it isn't set the
+whole notification is sent as JSON. WEBHOOK_TEMPLATE_FILE can be used instead for longer templates.
+*/
+func WebhookEnvStartup() WebhookConfiguration {
+	var webhookconf WebhookConfiguration
+
+	webhookconf.Urls = strings.Fields(os.Getenv("WEBHOOK_URLS"))
+	webhookconf.Template = os.Getenv("WEBHOOK_TEMPLATE")
+	if file := os.Getenv("WEBHOOK_TEMPLATE_FILE"); file != "" {
+		template, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Unable to read WEBHOOK_TEMPLATE_FILE %s: %s\n", file, err.Error())
+		}
+		webhookconf.Template = string(template)
+	}
+
+	// Which event types are sent, alerts are always sent
+	webhookconf.EventTypes = []string{"outage", "flapping"}
+	if os.Getenv("WEBHOOK_EVENT_TYPES") != "" {
+		webhookconf.EventTypes = strings.Split(os.Getenv("WEBHOOK_EVENT_TYPES"), ",")
+	}
+
+	webhookconf.Retries = 3
+	retries, err := strconv.Atoi(os.Getenv("WEBHOOK_RETRIES"))
+	if err == nil && retries >= 0 {
+		webhookconf.Retries = retries
+	}
+
+	webhookconf.Timeout = 10 * time.Second
+	timeout, err := strconv.Atoi(os.Getenv("WEBHOOK_TIMEOUT"))
+	if err == nil && timeout > 0 {
+		webhookconf.Timeout = time.Duration(timeout) * time.Second
+	}
+
+	return webhookconf
+}
+
+type AlertmanagerConfiguration struct {
+	Urls    []string
+	Resend  time.Duration
+	Retries int
+	Timeout time.Duration
+}
+
+// Load the Alertmanager settings, ALERTMANAGER_URLS is the base URL of each Alertmanager
+func AlertmanagerEnvStartup() AlertmanagerConfiguration {
+	var amconf AlertmanagerConfiguration
+
+	amconf.Urls = strings.Fields(os.Getenv("ALERTMANAGER_URLS"))
+
+	amconf.Resend = 60 * time.Second
+	resend, err := strconv.Atoi(os.Getenv("ALERTMANAGER_RESEND"))
+	if err == nil && resend > 0 {
+		amconf.Resend = time.Duration(resend) * time.Second
+	}
+
+	amconf.Retries = 3
+	amconf.Timeout = 10 * time.Second
+
+	return amconf
+}
@@ -0,0 +1,138 @@
+/*
+Event log for things that happen to a host over a period of time rather than a single sample,
+like an outage. We keep a bounded history in memory using a ring buffer so the newest event
+always replaces the oldest one, and hand every new or updated event off to any subscribers
+(Influx, notifications...).
+*/
+package events
+
+import (
+	"container/ring"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	TypeOutage   = "outage"
+	TypeAnomaly  = "anomaly"
+	TypeChange   = "change"
+	TypeFlapping = "flapping"
+
+	StateOpen   = "open"
+	StateClosed = "closed"
+)
+
+type Event struct {
+	Id          uint64        `json:"id"`
+	Type        string        `json:"type"`
+	State       string        `json:"state"`
+	Hostname    string        `json:"hostname"`
+	Ip          string        `json:"ip_address"`
+	Start       time.Time     `json:"start"`
+	End         time.Time     `json:"end,omitempty"`
+	Duration    time.Duration `json:"duration_ns"`
+	PacketsLost int           `json:"packets_lost"`
+	Metric      string        `json:"metric,omitempty"`
+	Before      float64       `json:"before"`
+	After       float64       `json:"after"`
+	CausedBy    string        `json:"caused_by,omitempty"`
+	Flapping    bool          `json:"flapping,omitempty"`
+	Message     string        `json:"message"`
+}
+
+var (
+	mu          sync.Mutex
+	history     *ring.Ring
+	nextId      uint64
+	subscribers []func(Event)
+)
+
+func init() {
+	history = ring.New(1000)
+}
+
+// Set the number of events we keep in memory, any events already recorded are dropped.
+func SetHistorySize(size int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if size < 1 {
+		size = 1
+	}
+	history = ring.New(size)
+}
+
+// Register a function to be called for every event that is recorded or updated.
+func Subscribe(handler func(Event)) {
+	mu.Lock()
+	defer mu.Unlock()
+	subscribers = append(subscribers, handler)
+}
+
+/*
+Reserve the Id for a new event that will be recorded later, so the caller can keep track of it
+before it has been handed to Record.
+*/
+func NewId() uint64 {
+	mu.Lock()
+	defer mu.Unlock()
+	nextId++
+	return nextId
+}
+
+/*
+Record a new event or update one we have already recorded. Events with a zero Id are new and get
+assigned the next Id which is returned so the caller can update the event later. The subscribers
+are called before Record returns so don't call it while holding a lock they might need.
+*/
+func Record(e Event) uint64 {
+	mu.Lock()
+	if e.Id == 0 {
+		nextId++
+		e.Id = nextId
+		history.Value = e
+		history = history.Next()
+	} else if !replace(e) {
+		// The Id was reserved with NewId or the event has aged out of the history, add it as the newest entry
+		history.Value = e
+		history = history.Next()
+	}
+	handlers := subscribers
+	mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(e)
+	}
+	return e.Id
+}
+
+// Replace an event in the history with the same Id. Must be called with the lock held.
+func replace(e Event) bool {
+	r := history
+	for i := 0; i < r.Len(); i++ {
+		if v, ok := r.Value.(Event); ok && v.Id == e.Id {
+			r.Value = e
+			return true
+		}
+		r = r.Next()
+	}
+	return false
+}
+
+// Return the events in the history newest first.
+func List() []Event {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var list []Event
+	history.Do(func(v any) {
+		if e, ok := v.(Event); ok {
+			list = append(list, e)
+		}
+	})
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id > list[j].Id
+	})
+	return list
+}
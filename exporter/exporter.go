@@ -0,0 +1,128 @@
+/*
+Exporters ship the stats off to wherever they are stored.
+
+An exporter gets the packets of every probe as it comes in and, every interval, the current windows
+of every host. Exporters that only care about one of them can leave the other empty. New backends
+only need to implement Exporter and be registered from main; stats doesn't know about any of them.
+*/
+package exporter
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cheetahfox/longping/stats"
+)
+
+type Exporter interface {
+	// Name used in the logs and the EXPORTERS config
+	Name() string
+	// Called after every probe with the packets it sent and the updated windows of that IP
+	Probe(result stats.ProbeResult)
+	// Called every interval with the current windows of every host
+	Windows(hosts []stats.HostSummary)
+	// Called when a host is removed or an IP goes away, stop reporting anything for the target
+	Retire(target stats.Target)
+	// Send anything that is still buffered
+	Flush()
+	// Flush and release any connections, the exporter isn't used again after this
+	Close()
+}
+
+type registered struct {
+	exporter Exporter
+	interval time.Duration
+}
+
+var (
+	mu        sync.Mutex
+	exporters []registered
+	running   sync.WaitGroup
+)
+
+func init() {
+	stats.SubscribeProbes(probe)
+	stats.SubscribeRetired(retire)
+}
+
+/*
+Add an exporter. Windows is called every interval once Start is called, an interval of 0 means
+the exporter only wants the probes.
+*/
+func Register(e Exporter, interval time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	exporters = append(exporters, registered{exporter: e, interval: interval})
+	slog.Info("Registered exporter: " + e.Name())
+}
+
+// Start sending the windows to each exporter on its own interval until ctx is cancelled.
+func Start(ctx context.Context) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, r := range exporters {
+		if r.interval <= 0 {
+			continue
+		}
+		running.Add(1)
+		go windowWriter(ctx, r)
+	}
+}
+
+func windowWriter(ctx context.Context, r registered) {
+	defer running.Done()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		r.exporter.Windows(stats.GetHostSummaries())
+		slog.Debug("Time to export windows to " + r.exporter.Name() + ": " + time.Since(start).String())
+	}
+}
+
+/*
+Stop the exporters after the context passed to Start has been cancelled. Each exporter gets the
+windows one last time so the final state isn't lost, then it's flushed and closed.
+*/
+func Shutdown() {
+	running.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	hosts := stats.GetHostSummaries()
+	for _, r := range exporters {
+		if r.interval > 0 {
+			r.exporter.Windows(hosts)
+		}
+		r.exporter.Flush()
+		r.exporter.Close()
+	}
+	exporters = nil
+}
+
+func probe(result stats.ProbeResult) {
+	mu.Lock()
+	list := exporters
+	mu.Unlock()
+
+	for _, r := range list {
+		r.exporter.Probe(result)
+	}
+}
+
+func retire(target stats.Target) {
+	mu.Lock()
+	list := exporters
+	mu.Unlock()
+
+	for _, r := range list {
+		r.exporter.Retire(target)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+The stats every exporter with a metrics model reports, shared so the OTLP metrics and the longping_
+Prometheus metrics always match.
+
+Names are in OpenTelemetry style with the unit kept separate, promName turns them into the
+Prometheus style with the unit as a suffix.
+*/
+package exporter
+
+import (
+	"strings"
+
+	"github.com/cheetahfox/longping/stats"
+)
+
+// A stat of a window, ok is false if the window doesn't have that stat
+type windowStat struct {
+	name  string
+	unit  string
+	help  string
+	value func(w stats.WindowSummary) (value float64, ok bool)
+}
+
+var windowStats = []windowStat{
+	{"longping.window.packet_loss", "1", "Packet loss over the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.Packetloss, true
+	}},
+	{"longping.window.packets_sent", "{packet}", "Number of packets sent within the time window", func(w stats.WindowSummary) (float64, bool) {
+		return float64(w.Sent), w.Type == stats.WindowTime
+	}},
+	{"longping.window.latency.avg", "s", "Average latency over the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.AvgLatencyNs.Seconds(), true
+	}},
+	{"longping.window.latency.max", "s", "Maximum latency over the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.MaxLatencyNs.Seconds(), true
+	}},
+	{"longping.window.latency.min", "s", "Minimum latency over the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.MinLatencyNs.Seconds(), true
+	}},
+	{"longping.window.latency.median", "s", "Median latency over the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.MedianNs.Seconds(), w.Window == "1000"
+	}},
+	{"longping.window.jitter", "s", "Jitter over the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.JitterNs.Seconds(), true
+	}},
+	{"longping.window.rfactor", "1", "Estimated E-model R-factor over the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.RFactor, w.Type == stats.WindowPackets && w.Mos != 0
+	}},
+	{"longping.window.mos", "1", "Estimated MOS score over the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.Mos, w.Type == stats.WindowPackets && w.Mos != 0
+	}},
+	{"longping.window.loss_burst.max", "{packet}", "Longest run of consecutive lost packets in the window", func(w stats.WindowSummary) (float64, bool) {
+		return float64(w.MaxBurst), w.Type == stats.WindowPackets
+	}},
+	{"longping.window.loss_burst.mean", "{packet}", "Mean length of the loss bursts in the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.MeanBurst, w.Type == stats.WindowPackets
+	}},
+	{"longping.window.loss_burst.count", "{burst}", "Number of loss bursts in the window", func(w stats.WindowSummary) (float64, bool) {
+		return float64(w.BurstCount), w.Type == stats.WindowPackets
+	}},
+	{"longping.window.gilbert.p", "1", "Gilbert-Elliott probability of going from good to bad in the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.GilbertP, w.Type == stats.WindowPackets
+	}},
+	{"longping.window.gilbert.r", "1", "Gilbert-Elliott probability of going from bad to good in the window", func(w stats.WindowSummary) (float64, bool) {
+		return w.GilbertR, w.Type == stats.WindowPackets
+	}},
+}
+
+// A stat of an IP that isn't tied to a window
+type ipStat struct {
+	name  string
+	unit  string
+	help  string
+	value func(ip stats.IpSummary) float64
+}
+
+var ipStats = []ipStat{
+	{"longping.latency.baseline", "s", "Learned baseline of the 1000 packet median latency", func(ip stats.IpSummary) float64 {
+		return ip.Baseline.BaselineNs.Seconds()
+	}},
+	{"longping.latency.deviation_score", "1", "How far the median latency is above the baseline in usual deviations", func(ip stats.IpSummary) float64 {
+		return ip.Baseline.DeviationScore
+	}},
+	{"longping.latency.anomaly", "1", "1 when the latency has been above the baseline for a sustained period", func(ip stats.IpSummary) float64 {
+		return boolToFloat(ip.Baseline.Anomaly)
+	}},
+	{"longping.flapping", "1", "1 when the IP is flapping between up and down", func(ip stats.IpSummary) float64 {
+		return boolToFloat(ip.Flapping)
+	}},
+	{"longping.state_changes", "{change}", "Number of up/down changes within the flap window", func(ip stats.IpSummary) float64 {
+		return float64(ip.StateChanges)
+	}},
+}
+
+// The running totals of an IP, these only ever go up
+type totalStat struct {
+	name  string
+	help  string
+	value func(ip stats.IpSummary) int64
+}
+
+var totalStats = []totalStat{
+	{"longping.packets.sent", "Total number of packets sent", func(ip stats.IpSummary) int64 { return int64(ip.TotalSent) }},
+	{"longping.packets.received", "Total number of packets received", func(ip stats.IpSummary) int64 { return int64(ip.TotalReceived) }},
+	{"longping.packets.lost", "Total number of packets lost", func(ip stats.IpSummary) int64 { return int64(ip.TotalLoss) }},
+	{"longping.packets.duplicates", "Total number of duplicate packets", func(ip stats.IpSummary) int64 { return int64(ip.TotalDuplicates) }},
+}
+
+// Round trip time buckets in seconds, from a LAN up to a very bad satellite link
+var rttBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Turn longping.window.latency.avg with a unit of s into longping_window_latency_avg_seconds
+func promName(name string, unit string) string {
+	name = strings.ReplaceAll(name, ".", "_")
+	switch unit {
+	case "s":
+		return name + "_seconds"
+	case "1":
+		if strings.HasSuffix(name, "_loss") {
+			return name + "_ratio"
+		}
+	}
+	return name
+}
@@ -0,0 +1,188 @@
+/*
+OpenTelemetry exporter, pushes the stats to an OTLP collector over gRPC or HTTP.
+
+The window gauges and totals are observed from the latest summary of each IP every time the periodic
+reader collects, and every probe's round trip time is recorded in a histogram. Every data point has
+hostname and ip_address attributes, the window gauges also get a window attribute.
+*/
+package exporter
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/stats"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+type Otlp struct {
+	provider *sdkmetric.MeterProvider
+	rtt      metric.Float64Histogram
+
+	windowGauges []metric.Float64ObservableGauge
+	ipGauges     []metric.Float64ObservableGauge
+	totals       []metric.Int64ObservableCounter
+
+	mu     sync.Mutex
+	latest map[string]stats.ProbeResult
+}
+
+// Create the OTLP exporter, metrics are pushed every interval by the periodic reader
+func NewOtlp(otlpconf config.OtlpConfiguration) (*Otlp, error) {
+	ctx := context.Background()
+
+	var exporter sdkmetric.Exporter
+	var err error
+	if otlpconf.Protocol == "http" {
+		var options []otlpmetrichttp.Option
+		if otlpconf.Endpoint != "" {
+			options = append(options, otlpmetrichttp.WithEndpoint(otlpconf.Endpoint))
+		}
+		if otlpconf.Insecure {
+			options = append(options, otlpmetrichttp.WithInsecure())
+		}
+		exporter, err = otlpmetrichttp.New(ctx, options...)
+	} else {
+		var options []otlpmetricgrpc.Option
+		if otlpconf.Endpoint != "" {
+			options = append(options, otlpmetricgrpc.WithEndpoint(otlpconf.Endpoint))
+		}
+		if otlpconf.Insecure {
+			options = append(options, otlpmetricgrpc.WithInsecure())
+		}
+		exporter, err = otlpmetricgrpc.New(ctx, options...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Otlp{
+		provider: sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(otlpconf.Interval))),
+			sdkmetric.WithResource(resource.NewSchemaless(attribute.String("service.name", "longping"))),
+		),
+		latest: make(map[string]stats.ProbeResult),
+	}
+	meter := o.provider.Meter("github.com/cheetahfox/longping")
+
+	o.rtt, err = meter.Float64Histogram("longping.probe.rtt",
+		metric.WithUnit("s"),
+		metric.WithDescription("Round trip time of each probe"),
+		metric.WithExplicitBucketBoundaries(rttBuckets...),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var observables []metric.Observable
+	for _, g := range windowStats {
+		gauge, err := meter.Float64ObservableGauge(g.name, metric.WithUnit(g.unit), metric.WithDescription(g.help))
+		if err != nil {
+			return nil, err
+		}
+		o.windowGauges = append(o.windowGauges, gauge)
+		observables = append(observables, gauge)
+	}
+	for _, g := range ipStats {
+		gauge, err := meter.Float64ObservableGauge(g.name, metric.WithUnit(g.unit), metric.WithDescription(g.help))
+		if err != nil {
+			return nil, err
+		}
+		o.ipGauges = append(o.ipGauges, gauge)
+		observables = append(observables, gauge)
+	}
+	for _, t := range totalStats {
+		counter, err := meter.Int64ObservableCounter(t.name, metric.WithUnit("{packet}"), metric.WithDescription(t.help))
+		if err != nil {
+			return nil, err
+		}
+		o.totals = append(o.totals, counter)
+		observables = append(observables, counter)
+	}
+
+	if _, err := meter.RegisterCallback(o.observe, observables...); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *Otlp) Name() string {
+	return "otlp"
+}
+
+// Record the round trip time of each packet and keep the summary around for the next collection
+func (o *Otlp) Probe(result stats.ProbeResult) {
+	attrs := metric.WithAttributes(attribute.String("hostname", result.Hostname), attribute.String("ip_address", result.Ip))
+	for _, packet := range result.Packets {
+		if packet.Reply {
+			o.rtt.Record(context.Background(), packet.Rtt.Seconds(), attrs)
+		}
+	}
+
+	o.mu.Lock()
+	o.latest[result.Hostname+"/"+result.Ip] = result
+	o.mu.Unlock()
+}
+
+func (o *Otlp) Windows(hosts []stats.HostSummary) {}
+
+/*
+Stop observing the gauges and totals of the target. The SDK has no way to forget the attributes of
+the histogram so it keeps its last counts until the next restart.
+*/
+func (o *Otlp) Retire(target stats.Target) {
+	o.mu.Lock()
+	delete(o.latest, target.Hostname+"/"+target.Ip)
+	o.mu.Unlock()
+}
+
+func (o *Otlp) Flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := o.provider.ForceFlush(ctx); err != nil {
+		slog.Warn("OTLP flush failed: " + err.Error())
+	}
+}
+
+func (o *Otlp) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := o.provider.Shutdown(ctx); err != nil {
+		slog.Error("OTLP shutdown failed: " + err.Error())
+	}
+}
+
+func (o *Otlp) observe(ctx context.Context, observer metric.Observer) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, result := range o.latest {
+		ip := result.Summary
+		target := []attribute.KeyValue{attribute.String("hostname", result.Hostname), attribute.String("ip_address", result.Ip)}
+		attrs := metric.WithAttributes(target...)
+
+		for i, t := range totalStats {
+			observer.ObserveInt64(o.totals[i], t.value(ip), attrs)
+		}
+		for i, g := range ipStats {
+			observer.ObserveFloat64(o.ipGauges[i], g.value(ip), attrs)
+		}
+		for _, w := range ip.Windows {
+			windowAttrs := metric.WithAttributes(append(target, attribute.String("window", w.Window))...)
+			for i, g := range windowStats {
+				if value, ok := g.value(w); ok {
+					observer.ObserveFloat64(o.windowGauges[i], value, windowAttrs)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package exporter
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/stats"
+	colmetric "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Collects every metric the exporter sends, by name
+type otlpReceiver struct {
+	mu      sync.Mutex
+	metrics map[string][]*metricpb.Metric
+	service string
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+	data, _ := io.ReadAll(body)
+	var export colmetric.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(data, &export); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	for _, rm := range export.GetResourceMetrics() {
+		for _, attr := range rm.GetResource().GetAttributes() {
+			if attr.GetKey() == "service.name" {
+				r.service = attr.GetValue().GetStringValue()
+			}
+		}
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				r.metrics[m.GetName()] = append(r.metrics[m.GetName()], m)
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	resp, _ := proto.Marshal(&colmetric.ExportMetricsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+// Everything received since the last take
+func (r *otlpReceiver) take() map[string][]*metricpb.Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	metrics := r.metrics
+	r.metrics = make(map[string][]*metricpb.Metric)
+	return metrics
+}
+
+func otlpAttrs(attrs []*commonpb.KeyValue) map[string]string {
+	values := make(map[string]string)
+	for _, attr := range attrs {
+		values[attr.GetKey()] = attr.GetValue().GetStringValue()
+	}
+	return values
+}
+
+func TestOtlpHttp(t *testing.T) {
+	receiver := &otlpReceiver{metrics: make(map[string][]*metricpb.Metric)}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	o, err := NewOtlp(config.OtlpConfiguration{
+		Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		Protocol: "http",
+		Insecure: true,
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	o.Probe(stats.ProbeResult{
+		Hostname: "router1",
+		Ip:       "192.0.2.1",
+		Packets: []stats.Packet{
+			{Reply: true, Rtt: 20 * time.Millisecond},
+			{Reply: false},
+		},
+		Summary: stats.IpSummary{
+			Ip:            "192.0.2.1",
+			TotalSent:     10,
+			TotalReceived: 9,
+			TotalLoss:     1,
+			Flapping:      true,
+			Windows: []stats.WindowSummary{
+				{Window: "100", Type: stats.WindowPackets, Packetloss: 0.1, AvgLatencyNs: 20 * time.Millisecond},
+			},
+		},
+	})
+	o.Flush()
+
+	metrics := receiver.take()
+	receiver.mu.Lock()
+	if receiver.service != "longping" {
+		t.Errorf("service.name is %q", receiver.service)
+	}
+	receiver.mu.Unlock()
+	target := map[string]string{"hostname": "router1", "ip_address": "192.0.2.1"}
+	window := map[string]string{"hostname": "router1", "ip_address": "192.0.2.1", "window": "100"}
+
+	gauge := func(name string, attrs map[string]string, want float64) {
+		t.Helper()
+		if len(metrics[name]) == 0 {
+			t.Errorf("%s wasn't sent", name)
+			return
+		}
+		m := metrics[name][0]
+		points := m.GetGauge().GetDataPoints()
+		if len(points) != 1 {
+			t.Errorf("%s has %d points, want 1", name, len(points))
+			return
+		}
+		if got := otlpAttrs(points[0].GetAttributes()); !equalAttrs(got, attrs) {
+			t.Errorf("%s has attributes %v, want %v", name, got, attrs)
+		}
+		if got := points[0].GetAsDouble(); got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	gauge("longping.window.packet_loss", window, 0.1)
+	gauge("longping.window.latency.avg", window, 0.02)
+	gauge("longping.flapping", target, 1)
+
+	sent := metrics["longping.packets.sent"]
+	if len(sent) == 0 {
+		t.Fatal("longping.packets.sent wasn't sent")
+	}
+	sum := sent[0].GetSum()
+	if !sum.GetIsMonotonic() || len(sum.GetDataPoints()) != 1 || sum.GetDataPoints()[0].GetAsInt() != 10 {
+		t.Errorf("longping.packets.sent is %v, want a monotonic counter of 10", sum)
+	}
+	if got := otlpAttrs(sum.GetDataPoints()[0].GetAttributes()); !equalAttrs(got, target) {
+		t.Errorf("longping.packets.sent has attributes %v", got)
+	}
+
+	rtt := metrics["longping.probe.rtt"]
+	if len(rtt) == 0 {
+		t.Fatal("longping.probe.rtt wasn't sent")
+	}
+	if rtt[0].GetUnit() != "s" {
+		t.Errorf("longping.probe.rtt has unit %q", rtt[0].GetUnit())
+	}
+	points := rtt[0].GetHistogram().GetDataPoints()
+	if len(points) != 1 {
+		t.Fatalf("longping.probe.rtt has %d points, want 1", len(points))
+	}
+	// Only the packet that got a reply is recorded
+	if points[0].GetCount() != 1 || points[0].GetSum() != 0.02 {
+		t.Errorf("longping.probe.rtt count %d sum %v, want 1 and 0.02", points[0].GetCount(), points[0].GetSum())
+	}
+	if !slices.Equal(points[0].GetExplicitBounds(), rttBuckets) {
+		t.Errorf("longping.probe.rtt bounds %v", points[0].GetExplicitBounds())
+	}
+	if got := otlpAttrs(points[0].GetAttributes()); !equalAttrs(got, target) {
+		t.Errorf("longping.probe.rtt has attributes %v", got)
+	}
+
+	// Nothing about the target is observed once it's retired
+	o.Retire(stats.Target{Hostname: "router1", Ip: "192.0.2.1"})
+	o.Flush()
+	for _, m := range receiver.take()["longping.window.packet_loss"] {
+		if len(m.GetGauge().GetDataPoints()) != 0 {
+			t.Error("retired target is still observed")
+		}
+	}
+}
+
+func equalAttrs(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
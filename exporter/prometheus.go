@@ -0,0 +1,596 @@
+/*
+Prometheus exporter, the gauges are updated after every probe and served from /metrics.
+
+The legacy metrics below are the original names, the longping_ metrics live in
+prometheus_longping.go. Either or both are served depending on PROMETHEUS_METRICS.
+*/
+package exporter
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/stats"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Register all of the metrics for Prometheus
+var (
+	// Prometheus metrics
+
+	apiRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "api_requests_total",
+		Help: "Total number of API requests",
+	}, []string{"method", "endpoint", "status"})
+
+	// Host metrics, these go in our own registry
+	TotalSent = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "total_sent",
+			Help: "Total number of packets sent",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	TotalReceived = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "total_received",
+			Help: "Total number of packets received",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	TotalLoss = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "total_loss",
+			Help: "Total number of packets lost",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	TotalDuplicates = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "total_duplicates",
+			Help: "Total number of duplicate packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Avg1000LatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "avg_1000_latency_ns",
+			Help: "Average latency in nanoseconds for the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Jitter1000Ns = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jitter_1000_ns",
+			Help: "Jitter in nanoseconds for the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Max1000LatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "max_1000_latency_ns",
+			Help: "Maximum latency in nanoseconds for the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Min1000LatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "min_1000_latency_ns",
+			Help: "Minimum latency in nanoseconds for the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Packetloss1000 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "packetloss_1000",
+			Help: "Packet loss for the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Avg100LatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "avg_100_latency_ns",
+			Help: "Average latency in nanoseconds for the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Jitter100Ns = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jitter_100_ns",
+			Help: "Jitter in nanoseconds for the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Max100LatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "max_100_latency_ns",
+			Help: "Maximum latency in nanoseconds for the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Min100LatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "min_100_latency_ns",
+			Help: "Minimum latency in nanoseconds for the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Packetloss100 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "packetloss_100",
+			Help: "Packet loss for the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Avg15LatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "avg_15_latency_ns",
+			Help: "Average latency in nanoseconds for the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Jitter15Ns = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "jitter_15_ns",
+			Help: "Jitter in nanoseconds for the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Max15LatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "max_15_latency_ns",
+			Help: "Maximum latency in nanoseconds for the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Min15LatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "min_15_latency_ns",
+			Help: "Minimum latency in nanoseconds for the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Packetloss15 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "packetloss_15",
+			Help: "Packet loss for the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	RFactor1000 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rfactor_1000",
+			Help: "Estimated E-model R-factor for the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	RFactor100 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rfactor_100",
+			Help: "Estimated E-model R-factor for the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	RFactor15 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rfactor_15",
+			Help: "Estimated E-model R-factor for the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Mos1000 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mos_1000",
+			Help: "Estimated MOS score for the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Mos100 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mos_100",
+			Help: "Estimated MOS score for the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Mos15 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mos_15",
+			Help: "Estimated MOS score for the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LossBurstMax1000 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loss_burst_max_1000",
+			Help: "Longest run of consecutive lost packets in the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LossBurstMean1000 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loss_burst_mean_1000",
+			Help: "Mean length of the loss bursts in the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LossBurstCount1000 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loss_burst_count_1000",
+			Help: "Number of loss bursts in the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	GilbertP1000 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gilbert_p_1000",
+			Help: "Gilbert-Elliott probability of going from good to bad in the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	GilbertR1000 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gilbert_r_1000",
+			Help: "Gilbert-Elliott probability of going from bad to good in the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LossBurstMax100 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loss_burst_max_100",
+			Help: "Longest run of consecutive lost packets in the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LossBurstMean100 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loss_burst_mean_100",
+			Help: "Mean length of the loss bursts in the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LossBurstCount100 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loss_burst_count_100",
+			Help: "Number of loss bursts in the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	GilbertP100 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gilbert_p_100",
+			Help: "Gilbert-Elliott probability of going from good to bad in the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	GilbertR100 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gilbert_r_100",
+			Help: "Gilbert-Elliott probability of going from bad to good in the last 100 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LossBurstMax15 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loss_burst_max_15",
+			Help: "Longest run of consecutive lost packets in the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LossBurstMean15 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loss_burst_mean_15",
+			Help: "Mean length of the loss bursts in the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LossBurstCount15 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "loss_burst_count_15",
+			Help: "Number of loss bursts in the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	GilbertP15 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gilbert_p_15",
+			Help: "Gilbert-Elliott probability of going from good to bad in the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	GilbertR15 = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gilbert_r_15",
+			Help: "Gilbert-Elliott probability of going from bad to good in the last 15 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Median1000LatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "median_1000_latency_ns",
+			Help: "Median latency in nanoseconds for the last 1000 packets",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LatencyBaselineNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "latency_baseline_ns",
+			Help: "Learned baseline of the 1000 packet median latency in nanoseconds",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LatencyDeviationScore = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "latency_deviation_score",
+			Help: "How far the 1000 packet median latency is above the baseline in usual deviations",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	LatencyAnomaly = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "latency_anomaly",
+			Help: "1 when the latency has been above the baseline for a sustained period",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	Flapping = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flapping",
+			Help: "1 when the IP is flapping between up and down",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	StateChanges = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "state_changes",
+			Help: "Number of up/down changes within the flap window",
+		},
+		[]string{"hostname", "ip_address"},
+	)
+	TimeWindowSent = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "time_window_sent",
+			Help: "Number of packets sent in the time window",
+		},
+		[]string{"hostname", "ip_address", "window"},
+	)
+	TimeWindowPacketloss = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "time_window_packetloss",
+			Help: "Packet loss for the time window",
+		},
+		[]string{"hostname", "ip_address", "window"},
+	)
+	TimeWindowAvgLatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "time_window_avg_latency_ns",
+			Help: "Average latency in nanoseconds for the time window",
+		},
+		[]string{"hostname", "ip_address", "window"},
+	)
+	TimeWindowMaxLatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "time_window_max_latency_ns",
+			Help: "Maximum latency in nanoseconds for the time window",
+		},
+		[]string{"hostname", "ip_address", "window"},
+	)
+	TimeWindowMinLatencyNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "time_window_min_latency_ns",
+			Help: "Minimum latency in nanoseconds for the time window",
+		},
+		[]string{"hostname", "ip_address", "window"},
+	)
+	TimeWindowJitterNs = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "time_window_jitter_ns",
+			Help: "Jitter in nanoseconds for the time window",
+		},
+		[]string{"hostname", "ip_address", "window"},
+	)
+	PingLatencyNs = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:                            "ping_latency_ns",
+		Help:                            "Histogram of ping latency in nanoseconds",
+		NativeHistogramBucketFactor:     1.1,
+		NativeHistogramMaxBucketNumber:  100,
+		NativeHistogramMinResetDuration: 1 * time.Hour,
+	}, []string{"hostname", "ip_address"},
+	)
+
+	// Always 1, the label sets are the targets we are currently monitoring
+	TargetInfo = promauto.With(Registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "longping_target_info",
+			Help: "Targets that are currently being monitored",
+		},
+		[]string{"hostname", "ip_address", "ip_version"},
+	)
+)
+
+type Prometheus struct {
+	legacy   bool
+	longping *longpingCollector
+}
+
+func NewPrometheus(promconf config.PrometheusConfiguration) *Prometheus {
+	p := &Prometheus{legacy: promconf.Legacy}
+	if promconf.Longping {
+		p.longping = newLongpingCollector()
+		Registry.MustRegister(p.longping, ProbeRttSeconds)
+	}
+	return p
+}
+
+func (p *Prometheus) Name() string {
+	return "prometheus"
+}
+
+// Prometheus scrapes whenever it likes so we keep the metrics current after every probe
+func (p *Prometheus) Probe(result stats.ProbeResult) {
+	TargetInfo.WithLabelValues(result.Hostname, result.Ip, ipVersion(result.Ip)).Set(1)
+	if p.legacy {
+		updatedHistogramMetrics(result)
+		prometheusUpdateMetrics(result.Hostname, result.Summary)
+	}
+	if p.longping != nil {
+		for _, packet := range result.Packets {
+			if packet.Reply {
+				ProbeRttSeconds.WithLabelValues(result.Hostname, result.Ip).Observe(packet.Rtt.Seconds())
+			}
+		}
+		p.longping.update(result.Hostname, result.Summary)
+	}
+}
+
+func (p *Prometheus) Windows(hosts []stats.HostSummary) {}
+
+// Delete the label sets of the target from every vector so it stops being exported
+func (p *Prometheus) Retire(target stats.Target) {
+	labels := prometheus.Labels{"hostname": target.Hostname, "ip_address": target.Ip}
+	var deleted int
+	for _, vector := range targetVectors() {
+		deleted = deleted + vector.DeletePartialMatch(labels)
+	}
+	if p.longping != nil {
+		p.longping.retire(target)
+	}
+	slog.Debug(fmt.Sprintf("Deleted %d series for %s ---> %s", deleted, target.Hostname, target.Ip))
+}
+
+func (p *Prometheus) Flush() {}
+
+func (p *Prometheus) Close() {}
+
+// updatedHistogramMetrics updates the histogram metrics with the latest ping latency
+func updatedHistogramMetrics(result stats.ProbeResult) {
+	// loop through the packets this covers cases where there are multiple RTTs
+	for _, packet := range result.Packets {
+		if !packet.Reply {
+			continue
+		}
+		PingLatencyNs.WithLabelValues(result.Hostname, result.Ip).Observe(float64(packet.Rtt.Nanoseconds()))
+		mesg := fmt.Sprintf("Updating histogram for %s with latency %d ms", result.Hostname, packet.Rtt.Milliseconds())
+		slog.Debug(mesg)
+	}
+}
+
+// I hate how this is just a big list of metrics that need to be updated
+func prometheusUpdateMetrics(hostname string, ip stats.IpSummary) {
+	// Update the metrics with the values from the summary
+	TotalSent.WithLabelValues(hostname, ip.Ip).Set(float64(ip.TotalSent))
+	TotalReceived.WithLabelValues(hostname, ip.Ip).Set(float64(ip.TotalReceived))
+	TotalLoss.WithLabelValues(hostname, ip.Ip).Set(float64(ip.TotalLoss))
+	TotalDuplicates.WithLabelValues(hostname, ip.Ip).Set(float64(ip.TotalDuplicates))
+	// Latency baseline
+	LatencyBaselineNs.WithLabelValues(hostname, ip.Ip).Set(float64(ip.Baseline.BaselineNs))
+	LatencyDeviationScore.WithLabelValues(hostname, ip.Ip).Set(ip.Baseline.DeviationScore)
+	LatencyAnomaly.WithLabelValues(hostname, ip.Ip).Set(boolToFloat(ip.Baseline.Anomaly))
+	// Flap detection
+	Flapping.WithLabelValues(hostname, ip.Ip).Set(boolToFloat(ip.Flapping))
+	StateChanges.WithLabelValues(hostname, ip.Ip).Set(float64(ip.StateChanges))
+
+	for _, w := range ip.Windows {
+		if w.Type == stats.WindowTime {
+			TimeWindowSent.WithLabelValues(hostname, ip.Ip, w.Window).Set(float64(w.Sent))
+			TimeWindowPacketloss.WithLabelValues(hostname, ip.Ip, w.Window).Set(w.Packetloss)
+			TimeWindowAvgLatencyNs.WithLabelValues(hostname, ip.Ip, w.Window).Set(float64(w.AvgLatencyNs))
+			TimeWindowMaxLatencyNs.WithLabelValues(hostname, ip.Ip, w.Window).Set(float64(w.MaxLatencyNs))
+			TimeWindowMinLatencyNs.WithLabelValues(hostname, ip.Ip, w.Window).Set(float64(w.MinLatencyNs))
+			TimeWindowJitterNs.WithLabelValues(hostname, ip.Ip, w.Window).Set(float64(w.JitterNs))
+			continue
+		}
+
+		gauges, ok := packetWindowGauges[w.Window]
+		if !ok {
+			continue
+		}
+		gauges.avg.WithLabelValues(hostname, ip.Ip).Set(float64(w.AvgLatencyNs))
+		gauges.jitter.WithLabelValues(hostname, ip.Ip).Set(float64(w.JitterNs))
+		gauges.max.WithLabelValues(hostname, ip.Ip).Set(float64(w.MaxLatencyNs))
+		gauges.min.WithLabelValues(hostname, ip.Ip).Set(float64(w.MinLatencyNs))
+		gauges.loss.WithLabelValues(hostname, ip.Ip).Set(w.Packetloss)
+		// VoIP quality estimates, a MOS of 0 means the window has nothing to estimate them from yet
+		if w.Mos != 0 {
+			gauges.rfactor.WithLabelValues(hostname, ip.Ip).Set(w.RFactor)
+			gauges.mos.WithLabelValues(hostname, ip.Ip).Set(w.Mos)
+		}
+		// Loss bursts
+		gauges.burstMax.WithLabelValues(hostname, ip.Ip).Set(float64(w.MaxBurst))
+		gauges.burstMean.WithLabelValues(hostname, ip.Ip).Set(w.MeanBurst)
+		gauges.burstCount.WithLabelValues(hostname, ip.Ip).Set(float64(w.BurstCount))
+		gauges.gilbertP.WithLabelValues(hostname, ip.Ip).Set(w.GilbertP)
+		gauges.gilbertR.WithLabelValues(hostname, ip.Ip).Set(w.GilbertR)
+		if w.Window == "1000" {
+			Median1000LatencyNs.WithLabelValues(hostname, ip.Ip).Set(float64(w.MedianNs))
+		}
+	}
+}
+
+// The gauges for each of the packet windows so we can update them in a loop
+type windowGauges struct {
+	avg, jitter, max, min, loss     *prometheus.GaugeVec
+	rfactor, mos                    *prometheus.GaugeVec
+	burstMax, burstMean, burstCount *prometheus.GaugeVec
+	gilbertP, gilbertR              *prometheus.GaugeVec
+}
+
+var packetWindowGauges = map[string]windowGauges{
+	"15": {
+		avg: Avg15LatencyNs, jitter: Jitter15Ns, max: Max15LatencyNs, min: Min15LatencyNs, loss: Packetloss15,
+		rfactor: RFactor15, mos: Mos15,
+		burstMax: LossBurstMax15, burstMean: LossBurstMean15, burstCount: LossBurstCount15,
+		gilbertP: GilbertP15, gilbertR: GilbertR15,
+	},
+	"100": {
+		avg: Avg100LatencyNs, jitter: Jitter100Ns, max: Max100LatencyNs, min: Min100LatencyNs, loss: Packetloss100,
+		rfactor: RFactor100, mos: Mos100,
+		burstMax: LossBurstMax100, burstMean: LossBurstMean100, burstCount: LossBurstCount100,
+		gilbertP: GilbertP100, gilbertR: GilbertR100,
+	},
+	"1000": {
+		avg: Avg1000LatencyNs, jitter: Jitter1000Ns, max: Max1000LatencyNs, min: Min1000LatencyNs, loss: Packetloss1000,
+		rfactor: RFactor1000, mos: Mos1000,
+		burstMax: LossBurstMax1000, burstMean: LossBurstMean1000, burstCount: LossBurstCount1000,
+		gilbertP: GilbertP1000, gilbertR: GilbertR1000,
+	},
+}
+
+// Every vector with hostname and ip_address labels
+func targetVectors() []*prometheus.MetricVec {
+	vectors := []*prometheus.MetricVec{
+		TotalSent.MetricVec, TotalReceived.MetricVec, TotalLoss.MetricVec, TotalDuplicates.MetricVec,
+		LatencyBaselineNs.MetricVec, LatencyDeviationScore.MetricVec, LatencyAnomaly.MetricVec,
+		Flapping.MetricVec, StateChanges.MetricVec, Median1000LatencyNs.MetricVec,
+		TimeWindowSent.MetricVec, TimeWindowPacketloss.MetricVec, TimeWindowAvgLatencyNs.MetricVec,
+		TimeWindowMaxLatencyNs.MetricVec, TimeWindowMinLatencyNs.MetricVec, TimeWindowJitterNs.MetricVec,
+		PingLatencyNs.MetricVec, ProbeRttSeconds.MetricVec, TargetInfo.MetricVec,
+	}
+	for _, gauges := range packetWindowGauges {
+		vectors = append(vectors,
+			gauges.avg.MetricVec, gauges.jitter.MetricVec, gauges.max.MetricVec, gauges.min.MetricVec, gauges.loss.MetricVec,
+			gauges.rfactor.MetricVec, gauges.mos.MetricVec,
+			gauges.burstMax.MetricVec, gauges.burstMean.MetricVec, gauges.burstCount.MetricVec,
+			gauges.gilbertP.MetricVec, gauges.gilbertR.MetricVec,
+		)
+	}
+	return vectors
+}
+
+func ipVersion(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "6"
+	}
+	return "4"
+}
+
+// Prometheus only deals in floats so a true is a 1
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,92 @@
+/*
+The longping_ Prometheus metrics.
+
+These are worked out from the latest summary of each target whenever we are scraped, the same way
+the OTLP exporter does it, so the totals can be real counters and every window is a label instead
+of its own metric. Everything is in seconds.
+*/
+package exporter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cheetahfox/longping/stats"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ProbeRttSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:                            "longping_probe_rtt_seconds",
+		Help:                            "Round trip time of each probe",
+		Buckets:                         rttBuckets,
+		NativeHistogramBucketFactor:     1.1,
+		NativeHistogramMaxBucketNumber:  100,
+		NativeHistogramMinResetDuration: 1 * time.Hour,
+	}, []string{"hostname", "ip_address"},
+	)
+)
+
+type longpingCollector struct {
+	windowDescs []*prometheus.Desc
+	ipDescs     []*prometheus.Desc
+	totalDescs  []*prometheus.Desc
+
+	mu     sync.Mutex
+	latest map[stats.Target]stats.IpSummary
+}
+
+func newLongpingCollector() *longpingCollector {
+	c := &longpingCollector{latest: make(map[stats.Target]stats.IpSummary)}
+	for _, w := range windowStats {
+		c.windowDescs = append(c.windowDescs, prometheus.NewDesc(promName(w.name, w.unit), w.help, []string{"hostname", "ip_address", "window"}, nil))
+	}
+	for _, i := range ipStats {
+		c.ipDescs = append(c.ipDescs, prometheus.NewDesc(promName(i.name, i.unit), i.help, []string{"hostname", "ip_address"}, nil))
+	}
+	for _, t := range totalStats {
+		c.totalDescs = append(c.totalDescs, prometheus.NewDesc(promName(t.name, "")+"_total", t.help, []string{"hostname", "ip_address"}, nil))
+	}
+	return c
+}
+
+func (c *longpingCollector) update(hostname string, ip stats.IpSummary) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latest[stats.Target{Hostname: hostname, Ip: ip.Ip}] = ip
+}
+
+func (c *longpingCollector) retire(target stats.Target) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.latest, target)
+}
+
+func (c *longpingCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, descs := range [][]*prometheus.Desc{c.windowDescs, c.ipDescs, c.totalDescs} {
+		for _, desc := range descs {
+			ch <- desc
+		}
+	}
+}
+
+func (c *longpingCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for target, ip := range c.latest {
+		for i, t := range totalStats {
+			ch <- prometheus.MustNewConstMetric(c.totalDescs[i], prometheus.CounterValue, float64(t.value(ip)), target.Hostname, target.Ip)
+		}
+		for i, s := range ipStats {
+			ch <- prometheus.MustNewConstMetric(c.ipDescs[i], prometheus.GaugeValue, s.value(ip), target.Hostname, target.Ip)
+		}
+		for _, w := range ip.Windows {
+			for i, s := range windowStats {
+				if value, ok := s.value(w); ok {
+					ch <- prometheus.MustNewConstMetric(c.windowDescs[i], prometheus.GaugeValue, value, target.Hostname, target.Ip, w.Window)
+				}
+			}
+		}
+	}
+}
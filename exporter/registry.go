@@ -0,0 +1,77 @@
+/*
+The probe metrics have their own registry so they aren't mixed in with the Go runtime, Fiber and
+the rest of our own metrics, those stay on the default registry as the self metrics.
+
+The static labels of a target (site, circuit_id...) are added when the metrics are gathered so they
+end up on every series with a hostname, whichever naming scheme is in use.
+*/
+package exporter
+
+import (
+	"sort"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+var Registry = prometheus.NewRegistry()
+
+type targetLabelGatherer struct {
+	gatherer prometheus.Gatherer
+}
+
+// The probe metrics with the static labels of each target
+func ProbeGatherer() prometheus.Gatherer {
+	return targetLabelGatherer{gatherer: Registry}
+}
+
+// The metrics about longping itself
+func SelfGatherer() prometheus.Gatherer {
+	return prometheus.DefaultGatherer
+}
+
+// Everything, what /metrics serves
+func Gatherer() prometheus.Gatherer {
+	return prometheus.Gatherers{ProbeGatherer(), SelfGatherer()}
+}
+
+func (g targetLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			addTargetLabels(m)
+		}
+	}
+	return families, err
+}
+
+func addTargetLabels(m *dto.Metric) {
+	var host string
+	existing := make(map[string]bool)
+	for _, label := range m.GetLabel() {
+		existing[label.GetName()] = true
+		if label.GetName() == "hostname" {
+			host = label.GetValue()
+		}
+	}
+	labels := config.GetTargetLabels(host)
+	if host == "" || len(labels) == 0 {
+		return
+	}
+
+	// The label pairs can be shared with the metric so build a new slice rather than appending
+	pairs := make([]*dto.LabelPair, 0, len(m.GetLabel())+len(labels))
+	pairs = append(pairs, m.GetLabel()...)
+	for name, value := range labels {
+		if existing[name] || value == "" {
+			continue
+		}
+		pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+	m.Label = pairs
+}
@@ -0,0 +1,387 @@
+/*
+Prometheus remote write exporter, for probes that Prometheus can't scrape.
+
+Every interval the probe metrics (what /metrics/probes serves) are gathered, encoded as a remote write request
+(protobuf, snappy compressed) and put on a queue. A sender pushes the queue to the endpoint oldest
+first so the samples arrive in order, and when the endpoint is down it backs off and keeps retrying
+while new requests pile up behind it. With a WAL directory the queue is kept on disk so nothing is
+lost across a restart.
+*/
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/stats"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	remoteWriteSent = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "remote_write_samples_sent_total",
+			Help: "Number of samples pushed to the remote write endpoint",
+		},
+	)
+	remoteWritePending = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "remote_write_samples_pending",
+			Help: "Number of samples waiting in the remote write queue",
+		},
+	)
+	remoteWriteDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "remote_write_samples_dropped_total",
+			Help: "Number of samples that were never pushed to the remote write endpoint",
+		},
+		[]string{"reason"},
+	)
+)
+
+const (
+	remoteWriteMinBackoff = time.Second
+	remoteWriteMaxBackoff = time.Minute
+)
+
+type RemoteWrite struct {
+	conf     config.RemoteWriteConfiguration
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	queue    *rwQueue
+
+	// Only one request is in flight at a time so they arrive in order
+	sendMu  sync.Mutex
+	wake    chan struct{}
+	done    chan struct{}
+	stopped sync.WaitGroup
+	closed  sync.Once
+}
+
+// A request the endpoint refused, sending it again won't help
+type rwRejected struct {
+	err error
+}
+
+func (r rwRejected) Error() string {
+	return r.err.Error()
+}
+
+/*
+Create the remote write exporter and start the sender. Register it with the push interval, the
+metrics from gatherer are pushed every time Windows is called.
+*/
+func NewRemoteWrite(rwconf config.RemoteWriteConfiguration, gatherer prometheus.Gatherer) (*RemoteWrite, error) {
+	queue, err := newRwQueue(rwconf.WalDir, rwconf.WalMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rw := &RemoteWrite{
+		conf:     rwconf,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: rwconf.Timeout},
+		queue:    queue,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	rw.stopped.Add(1)
+	go rw.sender()
+	return rw, nil
+}
+
+func (rw *RemoteWrite) Name() string {
+	return "remote_write"
+}
+
+func (rw *RemoteWrite) Probe(result stats.ProbeResult) {}
+
+// Gather the metrics and queue them up, the windows themselves come from the gatherer
+func (rw *RemoteWrite) Windows(hosts []stats.HostSummary) {
+	families, err := rw.gatherer.Gather()
+	if err != nil {
+		// Gather still returns what it could collect
+		slog.Warn("Error gathering metrics for remote write: " + err.Error())
+	}
+
+	body, samples := encodeWriteRequest(families, rw.conf.Labels, time.Now().UnixMilli())
+	if samples == 0 {
+		return
+	}
+	if err := rw.queue.push(snappy.Encode(nil, body), samples); err != nil {
+		slog.Error("Unable to queue remote write request: " + err.Error())
+		remoteWriteDropped.WithLabelValues("unwritable").Add(float64(samples))
+		return
+	}
+
+	select {
+	case rw.wake <- struct{}{}:
+	default:
+	}
+}
+
+// The gatherer drops retired targets on its own
+func (rw *RemoteWrite) Retire(target stats.Target) {}
+
+// Try to send everything that is queued, stops at the first failure
+func (rw *RemoteWrite) Flush() {
+	rw.sendMu.Lock()
+	defer rw.sendMu.Unlock()
+	for {
+		sent, err := rw.sendOldest()
+		if err != nil {
+			slog.Warn("Remote write flush failed: " + err.Error())
+			return
+		}
+		if !sent {
+			return
+		}
+	}
+}
+
+// Stop the sender, anything still queued is kept in the WAL for the next start
+func (rw *RemoteWrite) Close() {
+	rw.closed.Do(func() {
+		close(rw.done)
+		rw.stopped.Wait()
+		if pending := rw.queue.samples(); pending > 0 {
+			if rw.conf.WalDir == "" {
+				slog.Warn(fmt.Sprintf("Remote write stopped with %d samples that were never sent", pending))
+			} else {
+				slog.Info(fmt.Sprintf("Remote write stopped with %d samples left in the WAL", pending))
+			}
+		}
+	})
+}
+
+// Push the queue oldest first, backing off while the endpoint is failing
+func (rw *RemoteWrite) sender() {
+	defer rw.stopped.Done()
+	backoff := remoteWriteMinBackoff
+	for {
+		select {
+		case <-rw.done:
+			return
+		default:
+		}
+
+		rw.sendMu.Lock()
+		sent, err := rw.sendOldest()
+		rw.sendMu.Unlock()
+
+		switch {
+		case err != nil:
+			slog.Warn(fmt.Sprintf("Remote write failed, retrying in %s: %s", backoff, err.Error()))
+			select {
+			case <-rw.done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, remoteWriteMaxBackoff)
+		case sent:
+			backoff = remoteWriteMinBackoff
+		default:
+			// Nothing left to send, wait for the next request
+			select {
+			case <-rw.done:
+				return
+			case <-rw.wake:
+			}
+		}
+	}
+}
+
+/*
+Send the oldest request in the queue. Returns false if the queue is empty, a request the endpoint
+rejects is dropped and doesn't count as a failure. Must be called with sendMu held.
+*/
+func (rw *RemoteWrite) sendOldest() (bool, error) {
+	entry, body, err := rw.queue.oldest()
+	if err != nil {
+		slog.Error("Unable to read remote write WAL: " + err.Error())
+		rw.queue.remove(entry)
+		remoteWriteDropped.WithLabelValues("unreadable").Add(float64(entry.samples))
+		return true, nil
+	}
+	if body == nil {
+		return false, nil
+	}
+
+	err = rw.send(body)
+	var rejected rwRejected
+	if errors.As(err, &rejected) {
+		slog.Warn(fmt.Sprintf("Remote write endpoint rejected %d samples: %s", entry.samples, err.Error()))
+		rw.queue.remove(entry)
+		remoteWriteDropped.WithLabelValues("rejected").Add(float64(entry.samples))
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	rw.queue.remove(entry)
+	remoteWriteSent.Add(float64(entry.samples))
+	return true, nil
+}
+
+func (rw *RemoteWrite) send(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rw.conf.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rw.conf.Url, bytes.NewReader(body))
+	if err != nil {
+		return rwRejected{err}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "longping")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if rw.conf.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+rw.conf.BearerToken)
+	} else if rw.conf.Username != "" {
+		req.SetBasicAuth(rw.conf.Username, rw.conf.Password)
+	}
+
+	resp, err := rw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("remote write failed with status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	// Retrying a bad request won't change the answer, but the server being busy or down might
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return rwRejected{err}
+	}
+	return err
+}
+
+/*
+Encode the metric families as a remote write WriteRequest. The message is small enough that we
+write the protobuf by hand instead of pulling in the Prometheus server for prompb:
+
+	WriteRequest { repeated TimeSeries timeseries = 1; }
+	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+	Label        { string name = 1; string value = 2; }
+	Sample       { double value = 1; int64 timestamp = 2; }
+
+Histograms and summaries are split into their _bucket, _sum and _count series the same way a
+scrape would. Returns the encoded request and how many samples are in it.
+*/
+func encodeWriteRequest(families []*dto.MetricFamily, external map[string]string, timestamp int64) ([]byte, int) {
+	var body []byte
+	var samples int
+
+	series := func(name string, labels []*dto.LabelPair, extra map[string]string, value float64, ts int64) {
+		pairs := map[string]string{}
+		for name, value := range external {
+			pairs[name] = value
+		}
+		for _, label := range labels {
+			pairs[label.GetName()] = label.GetValue()
+		}
+		for name, value := range extra {
+			pairs[name] = value
+		}
+		pairs["__name__"] = name
+
+		names := make([]string, 0, len(pairs))
+		for name := range pairs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var timeseries []byte
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, pairs[name])
+			timeseries = protowire.AppendTag(timeseries, 1, protowire.BytesType)
+			timeseries = protowire.AppendBytes(timeseries, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(ts))
+		timeseries = protowire.AppendTag(timeseries, 2, protowire.BytesType)
+		timeseries = protowire.AppendBytes(timeseries, sample)
+
+		body = protowire.AppendTag(body, 1, protowire.BytesType)
+		body = protowire.AppendBytes(body, timeseries)
+		samples++
+	}
+
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			ts := timestamp
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			labels := m.GetLabel()
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				series(name, labels, nil, m.GetCounter().GetValue(), ts)
+			case dto.MetricType_GAUGE:
+				series(name, labels, nil, m.GetGauge().GetValue(), ts)
+			case dto.MetricType_UNTYPED:
+				series(name, labels, nil, m.GetUntyped().GetValue(), ts)
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				for _, q := range summary.GetQuantile() {
+					series(name, labels, map[string]string{"quantile": formatFloat(q.GetQuantile())}, q.GetValue(), ts)
+				}
+				series(name+"_sum", labels, nil, summary.GetSampleSum(), ts)
+				series(name+"_count", labels, nil, float64(summary.GetSampleCount()), ts)
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				histogram := m.GetHistogram()
+				infSeen := false
+				for _, b := range histogram.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						infSeen = true
+					}
+					series(name+"_bucket", labels, map[string]string{"le": formatFloat(b.GetUpperBound())}, float64(b.GetCumulativeCount()), ts)
+				}
+				if !infSeen {
+					series(name+"_bucket", labels, map[string]string{"le": "+Inf"}, float64(histogram.GetSampleCount()), ts)
+				}
+				series(name+"_sum", labels, nil, histogram.GetSampleSum(), ts)
+				series(name+"_count", labels, nil, float64(histogram.GetSampleCount()), ts)
+			}
+		}
+	}
+	return body, samples
+}
+
+// Format a bucket bound or quantile the same way the text exposition does
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,171 @@
+/*
+Queue of remote write requests waiting to be sent.
+
+Without a directory the requests are only kept in memory. With one every request is written to its
+own file first, like a write ahead log, and the files left over from before a restart are sent
+before anything new. The queue is bounded, once it grows past the max size the oldest requests are
+dropped.
+*/
+package exporter
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type rwQueue struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+	entries  []rwEntry
+}
+
+type rwEntry struct {
+	name    string
+	size    int64
+	samples int
+	// Only set when we don't have a directory to keep it in
+	data []byte
+}
+
+func newRwQueue(dir string, maxBytes int64) (*rwQueue, error) {
+	q := &rwQueue{dir: dir, maxBytes: maxBytes}
+	if dir == "" {
+		return q, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.rw"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		entry, err := readRwEntry(path)
+		if err != nil {
+			slog.Warn("Skipping remote write WAL file " + path + ": " + err.Error())
+			continue
+		}
+		q.entries = append(q.entries, entry)
+	}
+	q.update()
+	return q, nil
+}
+
+// The sample count is kept in the file name so we don't have to decode old requests on startup
+func readRwEntry(path string) (rwEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return rwEntry{}, err
+	}
+	name := filepath.Base(path)
+	_, count, found := strings.Cut(strings.TrimSuffix(name, ".rw"), "-")
+	if !found {
+		return rwEntry{}, fmt.Errorf("unexpected file name")
+	}
+	samples, err := strconv.Atoi(count)
+	if err != nil {
+		return rwEntry{}, err
+	}
+	return rwEntry{name: name, size: info.Size(), samples: samples}, nil
+}
+
+func (q *rwQueue) push(data []byte, samples int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry := rwEntry{
+		name:    fmt.Sprintf("%020d-%d.rw", time.Now().UnixNano(), samples),
+		size:    int64(len(data)),
+		samples: samples,
+	}
+	if q.dir == "" {
+		entry.data = data
+	} else if err := os.WriteFile(filepath.Join(q.dir, entry.name), data, 0o644); err != nil {
+		return err
+	}
+	q.entries = append(q.entries, entry)
+
+	// Drop the oldest requests until we are back under the limit
+	for q.size() > q.maxBytes && len(q.entries) > 1 {
+		oldest := q.entries[0]
+		q.entries = q.entries[1:]
+		if q.dir != "" {
+			os.Remove(filepath.Join(q.dir, oldest.name))
+		}
+		remoteWriteDropped.WithLabelValues("queue_full").Add(float64(oldest.samples))
+		slog.Warn(fmt.Sprintf("Remote write queue is full, dropped %d samples", oldest.samples))
+	}
+	q.update()
+	return nil
+}
+
+// The oldest request and its body, the body is nil if the queue is empty
+func (q *rwQueue) oldest() (rwEntry, []byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == 0 {
+		return rwEntry{}, nil, nil
+	}
+	entry := q.entries[0]
+	if q.dir == "" {
+		return entry, entry.data, nil
+	}
+	data, err := os.ReadFile(filepath.Join(q.dir, entry.name))
+	return entry, data, err
+}
+
+// Remove a request once it is sent, it may already be gone if the queue filled up while sending
+func (q *rwQueue) remove(entry rwEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.entries {
+		if q.entries[i].name == entry.name {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			if q.dir != "" {
+				os.Remove(filepath.Join(q.dir, entry.name))
+			}
+			break
+		}
+	}
+	q.update()
+}
+
+func (q *rwQueue) samples() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var samples int
+	for _, entry := range q.entries {
+		samples = samples + entry.samples
+	}
+	return samples
+}
+
+// Must be called with the lock held
+func (q *rwQueue) size() int64 {
+	var size int64
+	for _, entry := range q.entries {
+		size = size + entry.size
+	}
+	return size
+}
+
+// Must be called with the lock held
+func (q *rwQueue) update() {
+	var samples int
+	for _, entry := range q.entries {
+		samples = samples + entry.samples
+	}
+	remoteWritePending.Set(float64(samples))
+}
@@ -0,0 +1,348 @@
+package exporter
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A decoded TimeSeries with a single sample
+type rwSeries struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// Decode a WriteRequest the same way the remote end would
+func decodeWriteRequest(t *testing.T, body []byte) []rwSeries {
+	t.Helper()
+	var series []rwSeries
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 || num != 1 || typ != protowire.BytesType {
+			t.Fatalf("bad WriteRequest field %d type %d", num, typ)
+		}
+		body = body[n:]
+		ts, n := protowire.ConsumeBytes(body)
+		if n < 0 {
+			t.Fatal("bad TimeSeries")
+		}
+		body = body[n:]
+
+		s := rwSeries{labels: map[string]string{}}
+		var samples int
+		for len(ts) > 0 {
+			num, _, n := protowire.ConsumeTag(ts)
+			ts = ts[n:]
+			field, n := protowire.ConsumeBytes(ts)
+			if n < 0 {
+				t.Fatal("bad TimeSeries field")
+			}
+			ts = ts[n:]
+			switch num {
+			case 1:
+				var name, value string
+				for len(field) > 0 {
+					num, _, n := protowire.ConsumeTag(field)
+					field = field[n:]
+					str, n := protowire.ConsumeString(field)
+					field = field[n:]
+					if num == 1 {
+						name = str
+					} else {
+						value = str
+					}
+				}
+				s.labels[name] = value
+			case 2:
+				samples++
+				for len(field) > 0 {
+					num, typ, n := protowire.ConsumeTag(field)
+					field = field[n:]
+					switch {
+					case num == 1 && typ == protowire.Fixed64Type:
+						bits, n := protowire.ConsumeFixed64(field)
+						field = field[n:]
+						s.value = math.Float64frombits(bits)
+					case num == 2 && typ == protowire.VarintType:
+						v, n := protowire.ConsumeVarint(field)
+						field = field[n:]
+						s.timestamp = int64(v)
+					default:
+						t.Fatalf("bad Sample field %d type %d", num, typ)
+					}
+				}
+			default:
+				t.Fatalf("unexpected TimeSeries field %d", num)
+			}
+		}
+		if samples != 1 {
+			t.Fatalf("TimeSeries has %d samples, want 1", samples)
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+// Find the value of the series with exactly these labels, __name__ included
+func findSeries(t *testing.T, series []rwSeries, labels map[string]string) float64 {
+	t.Helper()
+	for _, s := range series {
+		if len(s.labels) != len(labels) {
+			continue
+		}
+		match := true
+		for name, value := range labels {
+			if s.labels[name] != value {
+				match = false
+			}
+		}
+		if match {
+			return s.value
+		}
+	}
+	t.Errorf("no series %v", labels)
+	return math.NaN()
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_sent_total", Help: "x"}, []string{"hostname"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_loss_ratio", Help: "x"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_rtt_seconds", Help: "x", Buckets: []float64{0.01, 0.1}})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "test_jitter_seconds", Help: "x", Objectives: map[float64]float64{0.5: 0.05}})
+	reg.MustRegister(counter, gauge, histogram, summary)
+
+	counter.WithLabelValues("router1").Add(3)
+	gauge.Set(0.25)
+	histogram.Observe(0.005)
+	histogram.Observe(0.05)
+	histogram.Observe(1)
+	summary.Observe(2)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, samples := encodeWriteRequest(families, map[string]string{"site": "edge1"}, 1700000000000)
+	series := decodeWriteRequest(t, body)
+	if samples != len(series) {
+		t.Errorf("reported %d samples, encoded %d", samples, len(series))
+	}
+	// counter + gauge + 3 buckets, sum and count + 1 quantile, sum and count
+	if len(series) != 10 {
+		t.Errorf("encoded %d series, want 10", len(series))
+	}
+	for _, s := range series {
+		if s.timestamp != 1700000000000 {
+			t.Errorf("series %v has timestamp %d", s.labels, s.timestamp)
+		}
+	}
+
+	tests := []struct {
+		labels map[string]string
+		want   float64
+	}{
+		{map[string]string{"__name__": "test_sent_total", "hostname": "router1", "site": "edge1"}, 3},
+		{map[string]string{"__name__": "test_loss_ratio", "site": "edge1"}, 0.25},
+		{map[string]string{"__name__": "test_rtt_seconds_bucket", "le": "0.01", "site": "edge1"}, 1},
+		{map[string]string{"__name__": "test_rtt_seconds_bucket", "le": "0.1", "site": "edge1"}, 2},
+		{map[string]string{"__name__": "test_rtt_seconds_bucket", "le": "+Inf", "site": "edge1"}, 3},
+		{map[string]string{"__name__": "test_rtt_seconds_sum", "site": "edge1"}, 1.055},
+		{map[string]string{"__name__": "test_rtt_seconds_count", "site": "edge1"}, 3},
+		{map[string]string{"__name__": "test_jitter_seconds", "quantile": "0.5", "site": "edge1"}, 2},
+		{map[string]string{"__name__": "test_jitter_seconds_sum", "site": "edge1"}, 2},
+		{map[string]string{"__name__": "test_jitter_seconds_count", "site": "edge1"}, 1},
+	}
+	for _, test := range tests {
+		if got := findSeries(t, series, test.labels); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%v = %v, want %v", test.labels, got, test.want)
+		}
+	}
+}
+
+// Records the requests and answers with the next status, 204 once they run out
+type rwReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (r *rwReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	compressed, _ := io.ReadAll(req.Body)
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header.Clone())
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status = r.statuses[0]
+		r.statuses = r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *rwReceiver) requests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.bodies)
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testGatherer(value float64) prometheus.Gatherer {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_value", Help: "x"})
+	gauge.Set(value)
+	reg.MustRegister(gauge)
+	return reg
+}
+
+func testRemoteWriteConf(url string) config.RemoteWriteConfiguration {
+	return config.RemoteWriteConfiguration{
+		Url:         url,
+		BearerToken: "secret",
+		Timeout:     5 * time.Second,
+		WalMaxBytes: 1024 * 1024,
+	}
+}
+
+// 5xx and 429 are retried with a backoff until the endpoint takes the request
+func TestRemoteWriteRetry(t *testing.T) {
+	receiver := &rwReceiver{statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	rw, err := NewRemoteWrite(testRemoteWriteConf(srv.URL), testGatherer(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	sent := testutil.ToFloat64(remoteWriteSent)
+	start := time.Now()
+	rw.Windows(nil)
+	waitFor(t, 10*time.Second, func() bool { return rw.queue.samples() == 0 })
+
+	if receiver.requests() != 3 {
+		t.Fatalf("got %d requests, want 3", receiver.requests())
+	}
+	// 1s after the first failure and 2s after the second
+	if elapsed := time.Since(start); elapsed < 3*time.Second {
+		t.Errorf("retried after %s, expected a backoff of at least 3s", elapsed)
+	}
+	if got := testutil.ToFloat64(remoteWriteSent) - sent; got != 1 {
+		t.Errorf("sent counter went up by %v, want 1", got)
+	}
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	header := receiver.headers[2]
+	if header.Get("Content-Encoding") != "snappy" || header.Get("Content-Type") != "application/x-protobuf" ||
+		header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" || header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("unexpected headers %v", header)
+	}
+	series := decodeWriteRequest(t, receiver.bodies[2])
+	if got := findSeries(t, series, map[string]string{"__name__": "test_value"}); got != 42 {
+		t.Errorf("test_value = %v, want 42", got)
+	}
+}
+
+// A 4xx other than 429 drops the request right away instead of blocking the queue
+func TestRemoteWriteRejected(t *testing.T) {
+	receiver := &rwReceiver{statuses: []int{http.StatusBadRequest}}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	rw, err := NewRemoteWrite(testRemoteWriteConf(srv.URL), testGatherer(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	dropped := testutil.ToFloat64(remoteWriteDropped.WithLabelValues("rejected"))
+	rw.Windows(nil)
+	waitFor(t, 5*time.Second, func() bool { return receiver.requests() == 1 && rw.queue.samples() == 0 })
+	if got := testutil.ToFloat64(remoteWriteDropped.WithLabelValues("rejected")) - dropped; got != 1 {
+		t.Errorf("rejected counter went up by %v, want 1", got)
+	}
+
+	// The next request goes out without waiting for a backoff
+	start := time.Now()
+	rw.Windows(nil)
+	waitFor(t, 5*time.Second, func() bool { return receiver.requests() == 2 && rw.queue.samples() == 0 })
+	if elapsed := time.Since(start); elapsed >= remoteWriteMinBackoff {
+		t.Errorf("second request took %s, the rejected one shouldn't cause a backoff", elapsed)
+	}
+}
+
+// Requests left in the WAL are sent by the next start before anything new
+func TestRemoteWriteWalReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	conf := testRemoteWriteConf(down.URL)
+	conf.WalDir = dir
+	rw, err := NewRemoteWrite(conf, testGatherer(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.Windows(nil)
+	rw.Close()
+	down.Close()
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("%d files in the WAL, want 1", len(files))
+	}
+
+	receiver := &rwReceiver{}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	conf.Url = srv.URL
+	rw, err = NewRemoteWrite(conf, testGatherer(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	waitFor(t, 5*time.Second, func() bool { return receiver.requests() == 1 && rw.queue.samples() == 0 })
+	receiver.mu.Lock()
+	series := decodeWriteRequest(t, receiver.bodies[0])
+	receiver.mu.Unlock()
+	if got := findSeries(t, series, map[string]string{"__name__": "test_value"}); got != 7 {
+		t.Errorf("replayed test_value = %v, want 7", got)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d files left in the WAL after replay", len(files))
+	}
+}
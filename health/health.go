@@ -1,15 +1,14 @@
 package health
 
 import (
+	"sync/atomic"
+
 	"github.com/cheetahfox/longping/config"
 	"github.com/gofiber/fiber/v2"
 )
 
-var InfluxReady bool
-
-func init() {
-	InfluxReady = false
-}
+// Written by the influx error handler while /readyz reads it
+var InfluxReady atomic.Bool
 
 func GetHealthz(c *fiber.Ctx) error {
 	// return &fiber.Error{}
@@ -17,7 +16,7 @@ func GetHealthz(c *fiber.Ctx) error {
 }
 
 func GetReadyz(c *fiber.Ctx) error {
-	if !InfluxReady && config.Config.InfluxEnabled {
+	if !InfluxReady.Load() && config.Config.InfluxEnabled {
 		return c.SendStatus(503)
 	}
 	return c.SendStatus(200)
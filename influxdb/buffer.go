@@ -0,0 +1,230 @@
+/*
+On-disk buffer for points that couldn't be written.
+
+When a batch fails to write it's saved to a file in the buffer directory instead of being retried in
+memory, and once InfluxDB is healthy again the files are replayed oldest first. The buffer is bounded,
+when it grows past the max size the oldest files are dropped. Batches the server rejects outright
+(a 4xx other than 429) are dropped since sending them again won't help.
+*/
+package influxdb
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	http2 "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	PointsBuffered = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "influx_points_buffered",
+			Help: "Number of points waiting in the on-disk buffer to be written to InfluxDB",
+		},
+		[]string{"writer"},
+	)
+	PointsDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "influx_points_dropped_total",
+			Help: "Number of points that were never written to InfluxDB",
+		},
+		[]string{"writer", "reason"},
+	)
+)
+
+type buffer struct {
+	name     string
+	dir      string
+	maxBytes int64
+	writer   api.WriteAPI
+	mu       sync.Mutex
+	files    []bufferFile
+}
+
+type bufferFile struct {
+	path   string
+	size   int64
+	points int
+}
+
+var buffers []*buffer
+
+/*
+Start buffering the failed batches of a writer in dir/name. Anything left over from before a
+restart is picked up and replayed along with the new batches.
+*/
+func newBuffer(name string, dir string, maxBytes int64, writer api.WriteAPI) (*buffer, error) {
+	b := &buffer{
+		name:     name,
+		dir:      filepath.Join(dir, name),
+		maxBytes: maxBytes,
+		writer:   writer,
+	}
+	if err := os.MkdirAll(b.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(b.dir, "*.lp"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		file, err := readBufferFile(path)
+		if err != nil {
+			slog.Warn("Skipping influx buffer file " + path + ": " + err.Error())
+			continue
+		}
+		b.files = append(b.files, file)
+	}
+	b.update()
+
+	writer.SetWriteFailedCallback(b.failed)
+	return b, nil
+}
+
+func readBufferFile(path string) (bufferFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return bufferFile{}, err
+	}
+	defer f.Close()
+
+	file := bufferFile{path: path}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		file.size = file.size + int64(len(scanner.Bytes())) + 1
+		file.points++
+	}
+	return file, scanner.Err()
+}
+
+// Called by the writer when a batch fails, returning true leaves the batch with the writer to retry
+func (b *buffer) failed(batch string, err http2.Error, attempts uint) bool {
+	points := strings.Count(batch, "\n")
+	if err.StatusCode >= 400 && err.StatusCode < 500 && err.StatusCode != 429 {
+		slog.Warn(fmt.Sprintf("InfluxDB rejected %d points for %s: %s", points, b.name, err.Error()))
+		PointsDropped.WithLabelValues(b.name, "rejected").Add(float64(points))
+		return false
+	}
+
+	if saveErr := b.save(batch, points); saveErr != nil {
+		slog.Error("Unable to buffer influx points: " + saveErr.Error())
+		return true
+	}
+	return false
+}
+
+func (b *buffer) save(batch string, points int) error {
+	if !strings.HasSuffix(batch, "\n") {
+		batch = batch + "\n"
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	path := filepath.Join(b.dir, fmt.Sprintf("%020d.lp", time.Now().UnixNano()))
+	if err := os.WriteFile(path, []byte(batch), 0o644); err != nil {
+		return err
+	}
+	b.files = append(b.files, bufferFile{path: path, size: int64(len(batch)), points: points})
+
+	// Drop the oldest files until we are back under the limit
+	for b.size() > b.maxBytes && len(b.files) > 1 {
+		oldest := b.files[0]
+		os.Remove(oldest.path)
+		b.files = b.files[1:]
+		PointsDropped.WithLabelValues(b.name, "buffer_full").Add(float64(oldest.points))
+		slog.Warn(fmt.Sprintf("Influx buffer for %s is full, dropped %d points", b.name, oldest.points))
+	}
+	b.update()
+	return nil
+}
+
+// Hand the buffered files back to the writer, if they fail again they come back as new files
+func (b *buffer) replay() {
+	b.mu.Lock()
+	files := b.files
+	b.files = nil
+	b.update()
+	b.mu.Unlock()
+
+	for _, file := range files {
+		data, err := os.ReadFile(file.path)
+		if err != nil {
+			slog.Error("Unable to read influx buffer file: " + err.Error())
+			PointsDropped.WithLabelValues(b.name, "unreadable").Add(float64(file.points))
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				b.writer.WriteRecord(line)
+			}
+		}
+		os.Remove(file.path)
+		slog.Info(fmt.Sprintf("Replayed %d buffered points for %s", file.points, b.name))
+	}
+	b.writer.Flush()
+}
+
+func (b *buffer) pending() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.files) > 0
+}
+
+// Must be called with the lock held
+func (b *buffer) size() int64 {
+	var size int64
+	for _, file := range b.files {
+		size = size + file.size
+	}
+	return size
+}
+
+// Must be called with the lock held
+func (b *buffer) update() {
+	var points int
+	for _, file := range b.files {
+		points = points + file.points
+	}
+	PointsBuffered.WithLabelValues(b.name).Set(float64(points))
+}
+
+// Replay the buffers whenever InfluxDB is healthy, runs until ctx is cancelled.
+func replayBuffers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var waiting []*buffer
+		for _, b := range buffers {
+			if b.pending() {
+				waiting = append(waiting, b)
+			}
+		}
+		if len(waiting) == 0 || !DbHealthCheck(0) {
+			continue
+		}
+		for _, b := range waiting {
+			b.replay()
+		}
+	}
+}
@@ -1,77 +1,200 @@
 package influxdb
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
-	"net"
 	"time"
 
 	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
 	"github.com/cheetahfox/longping/stats"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 )
 
 /*
-Creates a new InfluxDB connection and stores it in the global variable DbWrite
+Creates a new InfluxDB connection and stores it in the global variable DbWrite. If the database isn't
+there yet we keep trying in the background with a backoff until it is or ctx is cancelled, the
+exporter skips writing until we are connected and readiness reports not ready.
 */
-func NewInfluxConnection(config config.InfluxConfiguration) {
-	err := ConnectInflux(config)
-	if err != nil {
-		slog.Error("Error connecting to InfluxDB: " + err.Error())
+func NewInfluxConnection(ctx context.Context, config config.InfluxConfiguration) {
+	go func() {
+		backoff := time.Second
+		for {
+			err := ConnectInflux(config)
+			if err == nil {
+				return
+			}
+			slog.Error("Error connecting to InfluxDB: " + err.Error() + ", retrying in " + backoff.String())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = backoff * 2
+			if backoff > time.Minute {
+				backoff = time.Minute
+			}
+		}
+	}()
+}
+
+// Influx exporter, the windows are written every interval
+type Exporter struct{}
+
+func NewExporter() *Exporter {
+	return &Exporter{}
+}
+
+func (e *Exporter) Name() string {
+	return "influx"
+}
+
+/*
+Write every packet of the probe to longping_probes at the time it was sent, so percentiles and other
+windows can be worked out later. Only when INFLUX_PROBES is set.
+*/
+func (e *Exporter) Probe(result stats.ProbeResult) {
+	if !Connected() || DbProbeWrite == nil {
+		return
+	}
+	for _, packet := range result.Packets {
+		fields := map[string]interface{}{
+			"rtt_ns":   packet.Rtt.Nanoseconds(),
+			"received": packet.Reply,
+			"seq":      packet.Seq,
+		}
+		if packet.Reply {
+			fields["ttl"] = packet.Ttl
+		}
+		p := influxdb2.NewPoint("longping_probes", map[string]string{"Host": result.Hostname, "Ip": result.Ip}, fields, packet.Sent)
+		DbProbeWrite.WritePoint(p)
 	}
 }
 
-// This function will write the metrics to InfluxDB every X seconds
-func WriteRingMetrics(frequency int) {
-	ticker := time.NewTicker(time.Second * time.Duration(frequency))
-	var start time.Time
-	for range ticker.C {
-		for host := range stats.RingHosts {
-			for index := 0; index < len(stats.RingHosts[host].Ips); index++ {
-				hn := stats.RingHosts[host].Hostname
-				ip := stats.RingHosts[host].Ips[index].Ip
-				start = time.Now()
-
-				writeInflux("longping", hn, ip, "Total Packets Sent", float64(stats.RingHosts[host].Ips[index].TotalSent))
-				writeInflux("longping", hn, ip, "Total Packets Revc", float64(stats.RingHosts[host].Ips[index].TotalReceived))
-				writeInflux("longping", hn, ip, "Total Packets Loss", float64(stats.RingHosts[host].Ips[index].TotalLoss))
-
-				writeInflux("longping", hn, ip, "15 Packet loss", stats.RingHosts[host].Ips[index].Packetloss15)
-				writeInflux("longping", hn, ip, "100 Packet loss", stats.RingHosts[host].Ips[index].Packetloss100)
-				writeInflux("longping", hn, ip, "1k Packet loss", stats.RingHosts[host].Ips[index].Packetloss1000)
-
-				writeInflux("longping", hn, ip, "15 Packet Latency", float64(stats.RingHosts[host].Ips[index].Avg15LatencyNs.Nanoseconds()))
-				writeInflux("longping", hn, ip, "100 Packet Latency", float64(stats.RingHosts[host].Ips[index].Avg100LatencyNs.Nanoseconds()))
-				writeInflux("longping", hn, ip, "1k Packet Latency", float64(stats.RingHosts[host].Ips[index].Avg1000LatencyNs.Nanoseconds()))
-
-				writeInflux("longping", hn, ip, "15 Packet Max Latency", float64(stats.RingHosts[host].Ips[index].Max15LatencyNs.Nanoseconds()))
-				writeInflux("longping", hn, ip, "100 Packet Max Latency", float64(stats.RingHosts[host].Ips[index].Max100LatencyNs.Nanoseconds()))
-				writeInflux("longping", hn, ip, "1k Packet Max Latency", float64(stats.RingHosts[host].Ips[index].Max1000LatencyNs.Nanoseconds()))
-
-				writeInflux("longping", hn, ip, "15 Packet Min Latency", float64(stats.RingHosts[host].Ips[index].Min15LatencyNs.Nanoseconds()))
-				writeInflux("longping", hn, ip, "100 Packet Min Latency", float64(stats.RingHosts[host].Ips[index].Min100LatencyNs.Nanoseconds()))
-				writeInflux("longping", hn, ip, "1k Packet Min Latency", float64(stats.RingHosts[host].Ips[index].Min1000LatencyNs.Nanoseconds()))
-
-				writeInflux("longping", hn, ip, "15 Packet Jitter", float64(stats.RingHosts[host].Ips[index].Jitter15LatencyNs.Nanoseconds()))
-				writeInflux("longping", hn, ip, "100 Packet Jitter", float64(stats.RingHosts[host].Ips[index].Jitter100LatencyNs.Nanoseconds()))
-				writeInflux("longping", hn, ip, "1k Packet Jitter", float64(stats.RingHosts[host].Ips[index].Jitter1000LatencyNs.Nanoseconds()))
-
-				elapsed := time.Since(start)
-				slog.Debug("Time to write to InfluxDB: " + elapsed.String())
-			}
+// Write the windows of every IP to InfluxDB, all of the points from one tick share a timestamp
+func (e *Exporter) Windows(hosts []stats.HostSummary) {
+	if !Connected() {
+		return
+	}
+	now := time.Now()
+	for _, host := range hosts {
+		for _, ip := range host.Ips {
+			writeRingMetrics(host.Hostname, ip, now)
+		}
+	}
+}
+
+// Points are only written for the targets we still have so there is nothing to clean up
+func (e *Exporter) Retire(target stats.Target) {}
+
+func (e *Exporter) Flush() {
+	FlushInflux()
+}
+
+func (e *Exporter) Close() {
+	DisconnectInflux()
+}
+
+/*
+Write a single point with every stat for the IP as a field. The field names match the Prometheus
+metrics, the window (15, 100, 1000 or a time window like 5m) is part of the name.
+*/
+func writeRingMetrics(hn string, ip stats.IpSummary, ts time.Time) {
+	fields := map[string]interface{}{
+		"total_sent":              ip.TotalSent,
+		"total_received":          ip.TotalReceived,
+		"total_loss":              ip.TotalLoss,
+		"total_duplicates":        ip.TotalDuplicates,
+		"latency_baseline_ns":     ip.Baseline.BaselineNs.Nanoseconds(),
+		"latency_deviation_score": ip.Baseline.DeviationScore,
+		"latency_anomaly":         ip.Baseline.Anomaly,
+		"flapping":                ip.Flapping,
+		"state_changes":           ip.StateChanges,
+	}
+
+	for _, w := range ip.Windows {
+		fields["packetloss_"+w.Window] = w.Packetloss
+		fields["avg_"+w.Window+"_latency_ns"] = w.AvgLatencyNs.Nanoseconds()
+		fields["max_"+w.Window+"_latency_ns"] = w.MaxLatencyNs.Nanoseconds()
+		fields["min_"+w.Window+"_latency_ns"] = w.MinLatencyNs.Nanoseconds()
+		fields["jitter_"+w.Window+"_ns"] = w.JitterNs.Nanoseconds()
+
+		// Time windows don't have the VoIP or burst stats
+		if w.Type == stats.WindowTime {
+			fields["sent_"+w.Window] = w.Sent
+			continue
+		}
+		if w.Mos != 0 {
+			fields["rfactor_"+w.Window] = w.RFactor
+			fields["mos_"+w.Window] = w.Mos
+		}
+		fields["loss_burst_max_"+w.Window] = w.MaxBurst
+		fields["loss_burst_mean_"+w.Window] = w.MeanBurst
+		fields["loss_burst_count_"+w.Window] = w.BurstCount
+		fields["gilbert_p_"+w.Window] = w.GilbertP
+		fields["gilbert_r_"+w.Window] = w.GilbertR
+		if w.Window == "1000" {
+			fields["median_"+w.Window+"_latency_ns"] = w.MedianNs.Nanoseconds()
 		}
 	}
+
+	slog.Debug(fmt.Sprintf("Writing point --->  Measure: longping Host: %s Ip: %s Fields: %d", hn, ip.Ip, len(fields)))
+	p := influxdb2.NewPoint("longping", map[string]string{"Host": hn, "Ip": ip.Ip}, fields, ts)
+	DbWrite.WritePoint(p)
 }
 
-func writeInflux(measure string, host string, ip net.IP, metric string, value float64) {
-	s := fmt.Sprintf("%f", value)
-	slog.Debug("Writing point --->  Measure: " + measure + " Host: " + host + " Metric: " + metric + " Value: " + s)
-	p := influxdb2.NewPointWithMeasurement(measure)
+/*
+Write an event into its own measurement. Open events are written at their start time and closed
+events at their end time so both ends of an outage show up in the database.
+*/
+func WriteEvent(e events.Event) {
+	if !Connected() {
+		return
+	}
+	slog.Debug("Writing event ---> Type: " + e.Type + " State: " + e.State + " Host: " + e.Hostname)
+	p := influxdb2.NewPointWithMeasurement("longping_events")
+
+	p.AddTag("Host", e.Hostname)
+	p.AddTag("Ip", e.Ip)
+	p.AddTag("Type", e.Type)
+	p.AddTag("State", e.State)
+	p.AddField("id", int64(e.Id))
+	p.AddField("duration_ns", e.Duration.Nanoseconds())
+	p.AddField("packets_lost", e.PacketsLost)
+	p.AddField("message", e.Message)
+	if e.Metric != "" {
+		p.AddTag("Metric", e.Metric)
+		p.AddField("before", e.Before)
+		p.AddField("after", e.After)
+	}
+
+	if e.State == events.StateClosed {
+		p.SetTime(e.End)
+	} else {
+		p.SetTime(e.Start)
+	}
+
+	DbWrite.WritePoint(p)
+}
+
+/*
+Change points are also written as annotations with the title/text/tags fields Grafana expects so
+they can be overlaid on the latency and loss graphs.
+*/
+func WriteAnnotation(e events.Event) {
+	if !Connected() || e.Type != events.TypeChange {
+		return
+	}
+	p := influxdb2.NewPointWithMeasurement("longping_annotations")
 
-	p.AddTag("Host", host)
-	p.AddTag("Ip", ip.String())
-	p.SetTime(time.Now())
-	p.AddField(metric, value)
+	p.AddTag("Host", e.Hostname)
+	p.AddTag("Ip", e.Ip)
+	p.AddTag("Metric", e.Metric)
+	p.AddField("title", e.Metric+" change")
+	p.AddField("text", e.Message)
+	p.AddField("tags", e.Type+","+e.Metric+","+e.Hostname)
+	p.SetTime(e.Start)
 
 	DbWrite.WritePoint(p)
 }
@@ -5,39 +5,117 @@ import (
 	"fmt"
 	"net"
 	"net/url"
-	"os"
+	"sync/atomic"
 	"time"
 
-	"github.com/cheetahfox/Iot-local-midware/health"
 	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/health"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
 	"github.com/influxdata/influxdb-client-go/v2/domain"
 )
 
 var DbWrite api.WriteAPI
+var DbProbeWrite api.WriteAPI
 var dbclient influxdb2.Client
+var stopReplay context.CancelFunc
 
+// Set once the writers are ready, nothing is written to InfluxDB until then
+var connected atomic.Bool
+
+/*
+Connect to InfluxDB and set up the writers. Nothing is kept from a failed attempt so it can be
+called again until it works.
+*/
 func ConnectInflux(config config.InfluxConfiguration) error {
 	// Check if the Influxdb server is valid
 	if !dnsCheck(config.InfluxdbServer) {
 		return fmt.Errorf("influxdb server %s is not valid", config.InfluxdbServer)
 	}
 
-	dbclient = influxdb2.NewClient(config.InfluxdbServer, config.Token)
-	dbhealth, err := dbclient.Health(context.Background())
-	if (err != nil) && dbhealth.Status == domain.HealthCheckStatusFail {
-		return err
+	// Everything is built in locals and only published once it all worked
+	var server *v1Server
+	var client influxdb2.Client
+	var write, probeWrite api.WriteAPI
+	if config.Version == 1 {
+		server = newV1Server(config)
+		if err := server.ping(); err != nil {
+			return err
+		}
+		write = server.writeAPI("windows", config.Database, config.RetentionPolicy)
+		// The raw probes get their own writer so they can go to a different database
+		if config.Probes {
+			probeWrite = server.writeAPI("probes", config.ProbeDatabase, config.ProbeRetentionPolicy)
+		}
+	} else {
+		client = influxdb2.NewClient(config.InfluxdbServer, config.Token)
+		dbhealth, err := client.Health(context.Background())
+		if err != nil {
+			client.Close()
+			return err
+		}
+		if dbhealth.Status == domain.HealthCheckStatusFail {
+			client.Close()
+			return fmt.Errorf("influxdb server %s is unhealthy", config.InfluxdbServer)
+		}
+		write = client.WriteAPI(config.Org, config.Bucket)
+		// The raw probes get their own writer so they can go to a different bucket
+		if config.Probes {
+			probeWrite = client.WriteAPI(config.Org, config.ProbeBucket)
+		}
 	}
-	DbWrite = dbclient.WriteAPI(config.Org, config.Bucket)
+
+	// Failed batches go to disk and are replayed once the database is back
+	var created []*buffer
+	if config.BufferDir != "" {
+		b, err := newBuffer("windows", config.BufferDir, config.BufferMaxBytes, write)
+		if err != nil {
+			closeWriters(client, write, probeWrite)
+			return err
+		}
+		created = append(created, b)
+		if probeWrite != nil {
+			b, err := newBuffer("probes", config.BufferDir, config.BufferMaxBytes, probeWrite)
+			if err != nil {
+				closeWriters(client, write, probeWrite)
+				return err
+			}
+			created = append(created, b)
+		}
+	}
+
+	dbV1 = server
+	dbclient = client
+	DbWrite = write
+	DbProbeWrite = probeWrite
+	buffers = created
 	errorsCh := DbWrite.Errors()
 
+	if len(buffers) > 0 {
+		var ctx context.Context
+		ctx, stopReplay = context.WithCancel(context.Background())
+		go replayBuffers(ctx, 10*time.Second)
+	}
+
+	if DbProbeWrite != nil {
+		go func() {
+			for err := range DbProbeWrite.Errors() {
+				fmt.Printf("Influx probe write error: %s\n", err.Error())
+			}
+		}()
+	}
+
 	// Catch any write errors
 	go func() {
 		var errorCount int
 		for err := range errorsCh {
 			fmt.Printf("Influx write error: %s\n", err.Error())
-			errorCount++
+			if errorCount < config.InfluxMaxError {
+				errorCount++
+				if errorCount == config.InfluxMaxError {
+					fmt.Println("Maximum Influx error count reached!")
+				}
+			}
 
 			/*
 				check if the Influxdb database is healthy after seeing a error
@@ -45,29 +123,32 @@ func ConnectInflux(config config.InfluxConfiguration) error {
 				simpler and more reliable.
 			*/
 			if !DbHealthCheck(time.Duration(errorCount) * time.Second) {
-				health.InfluxReady = false
+				health.InfluxReady.Store(false)
 				fmt.Println("unhealthy Influxdb")
-				if errorCount > config.InfluxMaxError {
-					fmt.Println("Maximum Influx error count reached!")
-					DisconnectInflux()
-					time.Sleep(time.Duration(5) * time.Second)
-					os.Exit(1)
-				}
 			} else {
 				// Reset error count if the database is healthy
 				errorCount = 0
-				health.InfluxReady = true
+				health.InfluxReady.Store(true)
 			}
 		}
 	}()
 	fmt.Printf("Connected to Influxdb %s\n", config.InfluxdbServer)
-	health.InfluxReady = true
+	health.InfluxReady.Store(true)
+	connected.Store(true)
 
 	return nil
 }
 
+// Check if we are connected and the writers can be used
+func Connected() bool {
+	return connected.Load()
+}
+
 func DbHealthCheck(sleepTime time.Duration) bool {
 	time.Sleep(sleepTime)
+	if dbV1 != nil {
+		return dbV1.ping() == nil
+	}
 	dbhealth, err := dbclient.Health(context.Background())
 	if (err != nil) || dbhealth.Status == domain.HealthCheckStatusFail {
 		return false
@@ -90,7 +171,36 @@ func dnsCheck(dburl string) bool {
 	return false
 }
 
+// Send any points still sitting in the write buffer
+func FlushInflux() {
+	if !Connected() {
+		return
+	}
+	DbWrite.Flush()
+	if DbProbeWrite != nil {
+		DbProbeWrite.Flush()
+	}
+}
+
 func DisconnectInflux() {
-	health.InfluxReady = false
-	dbclient.Close()
+	health.InfluxReady.Store(false)
+	if !connected.Swap(false) {
+		return
+	}
+	if stopReplay != nil {
+		stopReplay()
+	}
+	closeWriters(dbclient, DbWrite, DbProbeWrite)
+}
+
+// The v1 writers aren't owned by a client so they have to be closed on their own
+func closeWriters(client influxdb2.Client, writers ...api.WriteAPI) {
+	for _, w := range writers {
+		if v1, ok := w.(*v1Writer); ok {
+			v1.Close()
+		}
+	}
+	if client != nil {
+		client.Close()
+	}
 }
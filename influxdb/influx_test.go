@@ -0,0 +1,49 @@
+package influxdb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cheetahfox/longping/config"
+)
+
+// A connect that fails part way must not leave any writers or buffers behind
+func TestConnectInfluxFailureKeepsNothing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	// A file where the buffer directory should be so newBuffer fails
+	notDir := filepath.Join(t.TempDir(), "buffer")
+	if err := os.WriteFile(notDir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := config.InfluxConfiguration{
+		Version:        1,
+		InfluxdbServer: srv.URL,
+		Database:       "longping",
+		Probes:         true,
+		ProbeDatabase:  "probes",
+		BufferDir:      notDir,
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := ConnectInflux(conf); err == nil {
+			t.Fatal("expected the connect to fail")
+		}
+	}
+
+	if DbWrite != nil || DbProbeWrite != nil || dbV1 != nil || dbclient != nil {
+		t.Error("globals were set by a failed connect")
+	}
+	if len(buffers) != 0 {
+		t.Errorf("%d buffers left behind", len(buffers))
+	}
+	if Connected() {
+		t.Error("connected after a failed connect")
+	}
+}
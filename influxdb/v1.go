@@ -0,0 +1,271 @@
+/*
+InfluxDB 1.x support.
+
+The v2 client only talks to the /api/v2 endpoints and wants an org and token, so for older servers we
+have our own writer that batches the same line protocol and POSTs it to /write with the database,
+retention policy and basic auth. It implements api.WriteAPI so the rest of the package doesn't care
+which version it is talking to.
+*/
+package influxdb
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	http2 "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+const (
+	v1BatchSize     = 1000
+	v1MaxBuffer     = 50000
+	v1FlushInterval = time.Second
+)
+
+// Server and credentials shared by the v1 writers, nil when we are talking to a v2 server
+type v1Server struct {
+	server   string
+	username string
+	password string
+	client   *http.Client
+}
+
+type v1Writer struct {
+	// Used as the writer label of the dropped points
+	name     string
+	server   *v1Server
+	url      string
+	mu       sync.Mutex
+	sendMu   sync.Mutex
+	buffer   []string
+	errCh    chan error
+	failedCb api.WriteFailedCallback
+	attempts uint
+	dropping bool
+	full     chan struct{}
+	done     chan struct{}
+	stopped  sync.WaitGroup
+	closed   sync.Once
+}
+
+var dbV1 *v1Server
+
+func newV1Server(influxconf config.InfluxConfiguration) *v1Server {
+	return &v1Server{
+		server:   strings.TrimSuffix(influxconf.InfluxdbServer, "/"),
+		username: influxconf.Username,
+		password: influxconf.Password,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Check the server is up, /ping returns a 204 on a healthy 1.x server
+func (s *v1Server) ping() error {
+	req, err := http.NewRequest(http.MethodGet, s.server+"/ping", nil)
+	if err != nil {
+		return err
+	}
+	s.auth(req)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *v1Server) auth(req *http.Request) {
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+}
+
+// Create a writer for a database and retention policy and start flushing it in the background
+func (s *v1Server) writeAPI(name string, database string, retentionPolicy string) *v1Writer {
+	query := url.Values{}
+	query.Set("db", database)
+	if retentionPolicy != "" {
+		query.Set("rp", retentionPolicy)
+	}
+	query.Set("precision", "ns")
+
+	w := &v1Writer{
+		name:   name,
+		server: s,
+		url:    s.server + "/write?" + query.Encode(),
+		full:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	w.stopped.Add(1)
+	go w.flusher()
+	return w
+}
+
+func (w *v1Writer) WriteRecord(line string) {
+	if !strings.HasSuffix(line, "\n") {
+		line = line + "\n"
+	}
+
+	w.mu.Lock()
+	w.buffer = append(w.buffer, line)
+	w.trim()
+	full := len(w.buffer) >= v1BatchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *v1Writer) WritePoint(point *write.Point) {
+	w.WriteRecord(write.PointToLineProtocol(point, time.Nanosecond))
+}
+
+// Send everything in the buffer, failed batches are kept for the next flush unless the callback says not to
+func (w *v1Writer) Flush() {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+
+	for {
+		w.mu.Lock()
+		count := len(w.buffer)
+		if count > v1BatchSize {
+			count = v1BatchSize
+		}
+		batch := w.buffer[:count:count]
+		w.buffer = w.buffer[count:]
+		w.mu.Unlock()
+		if count == 0 {
+			return
+		}
+
+		status, err := w.send(strings.Join(batch, ""))
+		retry := err != nil
+		if err != nil {
+			w.reportError(err)
+			w.mu.Lock()
+			failedCb := w.failedCb
+			w.mu.Unlock()
+			if failedCb != nil {
+				retry = failedCb(strings.Join(batch, ""), http2.Error{StatusCode: status, Err: err}, w.attempts)
+			}
+			w.attempts++
+		}
+		if retry {
+			w.mu.Lock()
+			w.buffer = append(batch, w.buffer...)
+			w.trim()
+			w.mu.Unlock()
+			return
+		}
+		w.attempts = 0
+		if err == nil {
+			w.mu.Lock()
+			w.dropping = false
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *v1Writer) Errors() <-chan error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.errCh == nil {
+		w.errCh = make(chan error)
+	}
+	return w.errCh
+}
+
+func (w *v1Writer) SetWriteFailedCallback(cb api.WriteFailedCallback) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failedCb = cb
+}
+
+// Drop the oldest points once the buffer is over the max. Must be called with mu held
+func (w *v1Writer) trim() {
+	over := len(w.buffer) - v1MaxBuffer
+	if over <= 0 {
+		return
+	}
+	w.buffer = w.buffer[over:]
+	PointsDropped.WithLabelValues(w.name, "overflow").Add(float64(over))
+	// Only warn once each time the server falls behind, not for every point
+	if !w.dropping {
+		w.dropping = true
+		slog.Warn(fmt.Sprintf("InfluxDB %s write buffer is full, dropping the oldest points", w.name))
+	}
+}
+
+// Stop the background flushing after a final flush
+func (w *v1Writer) Close() {
+	w.closed.Do(func() {
+		close(w.done)
+		w.stopped.Wait()
+		w.Flush()
+	})
+}
+
+func (w *v1Writer) flusher() {
+	defer w.stopped.Done()
+	ticker := time.NewTicker(v1FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		case <-w.full:
+		}
+		w.Flush()
+	}
+}
+
+// Returns the status code along with any error, 0 if we never got a response
+func (w *v1Writer) send(body string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, strings.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	w.server.auth(req)
+
+	resp, err := w.server.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("write failed with status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp.StatusCode, nil
+}
+
+func (w *v1Writer) reportError(err error) {
+	w.mu.Lock()
+	errCh := w.errCh
+	w.mu.Unlock()
+	// Don't hold up the writes if nobody is reading the errors right now
+	if errCh != nil {
+		select {
+		case errCh <- err:
+		default:
+		}
+	}
+}
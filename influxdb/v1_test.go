@@ -0,0 +1,99 @@
+package influxdb
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type v1Request struct {
+	path     string
+	db       string
+	rp       string
+	username string
+	password string
+	body     string
+}
+
+func TestV1Write(t *testing.T) {
+	var mu sync.Mutex
+	var requests []v1Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		username, password, _ := r.BasicAuth()
+		mu.Lock()
+		requests = append(requests, v1Request{
+			path:     r.URL.Path,
+			db:       r.URL.Query().Get("db"),
+			rp:       r.URL.Query().Get("rp"),
+			username: username,
+			password: password,
+			body:     string(body),
+		})
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	server := newV1Server(config.InfluxConfiguration{InfluxdbServer: srv.URL + "/", Username: "longping", Password: "secret"})
+	writer := server.writeAPI("windows", "pings", "autogen")
+	defer writer.Close()
+
+	when := time.Unix(1700000000, 0)
+	writer.WritePoint(write.NewPoint("ping", map[string]string{"hostname": "router1"}, map[string]interface{}{"loss": 0.5}, when))
+	writer.WriteRecord("ping,hostname=router2 loss=0 1700000000000000000")
+	writer.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	got := requests[0]
+	want := v1Request{
+		path:     "/write",
+		db:       "pings",
+		rp:       "autogen",
+		username: "longping",
+		password: "secret",
+		body:     "ping,hostname=router1 loss=0.5 1700000000000000000\nping,hostname=router2 loss=0 1700000000000000000\n",
+	}
+	if got != want {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+// Without a retention policy the rp param is left off so the server uses the default one
+func TestV1WriteDefaultRetentionPolicy(t *testing.T) {
+	server := newV1Server(config.InfluxConfiguration{InfluxdbServer: "http://localhost:8086"})
+	writer := server.writeAPI("windows", "pings", "")
+	defer writer.Close()
+	if strings.Contains(writer.url, "rp=") {
+		t.Errorf("url %s has a retention policy", writer.url)
+	}
+}
+
+// Points past the max buffer are dropped oldest first and counted
+func TestV1WriteOverflow(t *testing.T) {
+	// No flusher so nothing is sent while we fill it
+	writer := &v1Writer{name: "test", full: make(chan struct{}, 1)}
+	dropped := testutil.ToFloat64(PointsDropped.WithLabelValues("test", "overflow"))
+
+	for i := 0; i < v1MaxBuffer+5; i++ {
+		writer.WriteRecord("ping loss=0")
+	}
+	if len(writer.buffer) != v1MaxBuffer {
+		t.Errorf("buffer has %d points, want %d", len(writer.buffer), v1MaxBuffer)
+	}
+	if got := testutil.ToFloat64(PointsDropped.WithLabelValues("test", "overflow")) - dropped; got != 5 {
+		t.Errorf("dropped %v points, want 5", got)
+	}
+}
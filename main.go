@@ -18,6 +18,7 @@ Joshua Snyder 9/14/2022
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -26,8 +27,12 @@ import (
 	"time"
 
 	"github.com/ansrivas/fiberprometheus/v2"
+	"github.com/cheetahfox/longping/alerts"
 	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
+	"github.com/cheetahfox/longping/exporter"
 	"github.com/cheetahfox/longping/influxdb"
+	"github.com/cheetahfox/longping/notify"
 	"github.com/cheetahfox/longping/router"
 	"github.com/cheetahfox/longping/stats"
 	"github.com/gofiber/fiber/v2"
@@ -66,11 +71,93 @@ func main() {
 		slog.Info("Log level set to " + config.Config.LogLevel)
 	}
 
+	events.SetHistorySize(config.Config.EventHistory)
+
+	// Listen for Sigint or SigTerm, everything we start is stopped by cancelling this context.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Webhook notifications are optional
+	webhookConfig := config.WebhookEnvStartup()
+	if len(webhookConfig.Urls) > 0 {
+		err := notify.StartWebhooks(webhookConfig)
+		if err != nil {
+			slog.Error("Error in notify.StartWebhooks:" + err.Error())
+			panic(err)
+		}
+	}
+
+	// Pushing alerts to Alertmanager is optional
+	alertmanagerConfig := config.AlertmanagerEnvStartup()
+	if len(alertmanagerConfig.Urls) > 0 {
+		notify.StartAlertmanager(alertmanagerConfig)
+	}
+
+	// Restore the rings from the last snapshot before we start pinging
+	if config.Config.SnapshotFile != "" {
+		maxAge := time.Duration(config.Config.SnapshotMaxAge) * time.Second
+		if err := stats.LoadSnapshot(config.Config.SnapshotFile, maxAge); err != nil {
+			slog.Error("Error loading snapshot:" + err.Error())
+		}
+	}
+
+	// Set up the exporters before we start pinging so they see every probe
+	for _, name := range config.Config.Exporters {
+		switch name {
+		case "prometheus":
+			exporter.Register(exporter.NewPrometheus(config.PrometheusEnvStartup()), 0)
+		case "influx":
+			influx := config.InfluxEnvStartup()
+			influxdb.NewInfluxConnection(ctx, influx)
+			events.Subscribe(influxdb.WriteEvent)
+			events.Subscribe(influxdb.WriteAnnotation)
+			exporter.Register(influxdb.NewExporter(), influx.Interval)
+		case "otlp":
+			otlp, err := exporter.NewOtlp(config.OtlpEnvStartup())
+			if err != nil {
+				slog.Error("Unable to start the OTLP exporter: " + err.Error())
+				continue
+			}
+			exporter.Register(otlp, 0)
+		case "remote_write":
+			remoteWrite, err := config.RemoteWriteEnvStartup()
+			if err != nil {
+				slog.Error("Unable to start the remote write exporter: " + err.Error())
+				continue
+			}
+			rw, err := exporter.NewRemoteWrite(remoteWrite, exporter.ProbeGatherer())
+			if err != nil {
+				slog.Error("Unable to start the remote write exporter: " + err.Error())
+				continue
+			}
+			exporter.Register(rw, remoteWrite.Interval)
+		default:
+			slog.Warn("Unknown exporter: " + name)
+		}
+	}
+	exporter.Start(ctx)
+
 	hosts := config.GetHosts()
 
 	for _, host := range hosts {
 		stats.InitHost(host)
-		stats.RegisterRingHost(host)
+		stats.RegisterRingHost(ctx, host)
+	}
+	stats.ForgetSnapshot()
+
+	// Pick up DNS changes, the IPs that go away are retired from the exporters
+	if config.Config.DnsRefreshInterval > 0 {
+		go stats.DnsRefresher(ctx, time.Duration(config.Config.DnsRefreshInterval)*time.Second)
+	}
+
+	if config.Config.SnapshotFile != "" {
+		go stats.SnapshotWriter(ctx, config.Config.SnapshotFile, time.Duration(config.Config.SnapshotInterval)*time.Second)
+	}
+
+	// Only run the alert engine if we have rules
+	alertConfig := config.AlertEnvStartup()
+	if len(alertConfig.Rules) > 0 {
+		go alerts.Start(ctx, alertConfig)
 	}
 
 	// Always start the prometheus metrics and health checks
@@ -89,32 +176,40 @@ func main() {
 		}
 	}()
 
-	// Make influxdb optional
-	if config.Config.InfluxEnabled {
-		influx := config.InfluxEnvStartup()
-		influxdb.NewInfluxConnection(influx)
-		// need to wait for the influxdb to connect before we can start sending data.
-		time.Sleep(time.Duration(time.Second * 1))
-		influxdb.WriteRingMetrics(15)
-	}
-
 	slog.Debug("Startup successful: waiting for shutdown signal")
 
-	// Listen for Sigint or SigTerm and exit if you get them.
-	sigs := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
+	<-ctx.Done()
+	stop()
+	fmt.Println("Shutting down...")
 
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	/*
+		Stop taking requests and wait for the ping threads to finish so the last results make it
+		into the snapshot and out to the exporters. If something hangs we give up after the timeout
+		rather than never exiting.
+	*/
+	timeout := time.Duration(config.Config.ShutdownTimeout) * time.Second
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
+	finished := make(chan struct{})
 	go func() {
-		sig := <-sigs
-		slog.Debug("Received shutdown signal:" + sig.String())
-		done <- true
+		defer close(finished)
+		if err := longping.ShutdownWithContext(shutdownCtx); err != nil {
+			slog.Error("Error stopping Fiber app:" + err.Error())
+		}
+		stats.WaitProbes()
+		if config.Config.SnapshotFile != "" {
+			if err := stats.SaveSnapshot(config.Config.SnapshotFile); err != nil {
+				slog.Error("Error saving snapshot:" + err.Error())
+			}
+		}
+		exporter.Shutdown()
 	}()
 
-	<-done
-	fmt.Println("Shutting down...")
-	if config.Config.InfluxEnabled {
-		influxdb.DisconnectInflux()
+	select {
+	case <-finished:
+		slog.Info("Shutdown complete")
+	case <-shutdownCtx.Done():
+		slog.Error("Shutdown timed out after " + timeout.String())
 	}
 }
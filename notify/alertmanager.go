@@ -0,0 +1,97 @@
+/*
+Push alerts to Prometheus Alertmanager using the v2 API so routing and silencing can be done with the
+rest of our alerts. Alertmanager expects firing alerts to be sent again every so often or it will
+resolve them on its own, so we re-send everything that is firing every Resend interval. Resolved
+alerts are sent once with endsAt set.
+*/
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/cheetahfox/longping/alerts"
+	"github.com/cheetahfox/longping/config"
+)
+
+// An alert in the format of the Alertmanager v2 API
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      *time.Time        `json:"endsAt,omitempty"`
+}
+
+var alertmanagers []*webhook
+
+// Start pushing alerts to each of the Alertmanagers
+func StartAlertmanager(amconf config.AlertmanagerConfiguration) {
+	for _, url := range amconf.Urls {
+		url = strings.TrimSuffix(url, "/") + "/api/v2/alerts"
+		alertmanagers = append(alertmanagers, newWebhook(url, amconf.Retries, amconf.Timeout))
+	}
+
+	alerts.Subscribe(func(a alerts.Alert) {
+		pushAlertmanager([]alerts.Alert{a})
+	})
+
+	go func() {
+		ticker := time.NewTicker(amconf.Resend)
+		for range ticker.C {
+			// Only re-send the alerts Alertmanager has already been told about
+			var firing []alerts.Alert
+			for _, a := range alerts.List(alerts.StateFiring) {
+				if a.Notified {
+					firing = append(firing, a)
+				}
+			}
+			if len(firing) > 0 {
+				pushAlertmanager(firing)
+			}
+		}
+	}()
+	slog.Info(fmt.Sprintf("Pushing alerts to %d Alertmanagers", len(alertmanagers)))
+}
+
+func toAlertmanager(a alerts.Alert) alertmanagerAlert {
+	am := alertmanagerAlert{
+		Labels: map[string]string{
+			"alertname":  a.Rule,
+			"hostname":   a.Hostname,
+			"ip_address": a.Ip,
+			"field":      a.Field,
+		},
+		Annotations: map[string]string{
+			"summary": a.Message,
+			"value":   fmt.Sprintf("%g", a.Value),
+		},
+		StartsAt: a.FiredAt,
+	}
+	if len(a.Groups) > 0 {
+		am.Labels["group"] = strings.Join(a.Groups, ",")
+	}
+	if a.State == alerts.StateResolved {
+		endsAt := a.ResolvedAt
+		am.EndsAt = &endsAt
+	}
+	return am
+}
+
+func pushAlertmanager(list []alerts.Alert) {
+	var payload []alertmanagerAlert
+	for _, a := range list {
+		payload = append(payload, toAlertmanager(a))
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Unable to encode alerts for Alertmanager: " + err.Error())
+		return
+	}
+	for _, am := range alertmanagers {
+		am.enqueue(b)
+	}
+}
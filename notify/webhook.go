@@ -0,0 +1,209 @@
+/*
+Webhook notifications for alerts and events.
+
+Every alert that fires or resolves and every event of the configured types is rendered with the
+webhook template and POSTed to each of the webhook URLs. Each URL gets its own queue and worker so
+a slow or broken receiver doesn't hold up the others, and failed posts are retried with an
+exponential backoff before we give up on them.
+*/
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/cheetahfox/longping/alerts"
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	KindAlert = "alert"
+	KindEvent = "event"
+
+	queueSize = 100
+)
+
+// The data handed to the webhook template
+type Notification struct {
+	Kind  string        `json:"kind"`
+	Time  time.Time     `json:"time"`
+	Alert *alerts.Alert `json:"alert,omitempty"`
+	Event *events.Event `json:"event,omitempty"`
+}
+
+type webhook struct {
+	url     string
+	queue   chan []byte
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+var webhookDropped = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "webhook_dropped_total",
+		Help: "Number of webhook notifications that were never delivered",
+	},
+	[]string{"reason"},
+)
+
+var (
+	webhooks   []*webhook
+	body       *template.Template
+	eventTypes map[string]bool
+	sentMu     sync.Mutex
+	sentEvents = make(map[uint64]bool)
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+/*
+Parse the template, start a worker for each webhook URL and subscribe to the alerts and events.
+Returns an error if the template doesn't parse.
+*/
+func StartWebhooks(webhookconf config.WebhookConfiguration) error {
+	text := webhookconf.Template
+	if text == "" {
+		text = "{{ json . }}"
+	}
+	t, err := template.New("webhook").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return err
+	}
+	body = t
+
+	eventTypes = make(map[string]bool)
+	for _, eventType := range webhookconf.EventTypes {
+		eventTypes[eventType] = true
+	}
+
+	for _, url := range webhookconf.Urls {
+		webhooks = append(webhooks, newWebhook(url, webhookconf.Retries, webhookconf.Timeout))
+	}
+
+	alerts.Subscribe(Alert)
+	events.Subscribe(Event)
+	slog.Info(fmt.Sprintf("Sending notifications to %d webhooks", len(webhooks)))
+	return nil
+}
+
+// Send a notification for an alert that has fired or resolved
+func Alert(a alerts.Alert) {
+	send(Notification{Kind: KindAlert, Time: time.Now(), Alert: &a})
+}
+
+/*
+Send a notification for an event if it's one of the types we care about. Events caused by a
+parent being down are skipped since the parent's own event covers them, unless we already sent
+the start of the event before we knew about the parent. Outages of a flapping IP are held back
+the same way; the flapping event tells us when it has settled down.
+*/
+func Event(e events.Event) {
+	if !eventTypes[e.Type] {
+		return
+	}
+
+	sentMu.Lock()
+	sent := sentEvents[e.Id]
+	held := e.CausedBy != "" || e.Flapping
+	if e.State == events.StateOpen && !held {
+		sentEvents[e.Id] = true
+	} else if e.State == events.StateClosed {
+		delete(sentEvents, e.Id)
+	}
+	sentMu.Unlock()
+
+	if held && !sent {
+		return
+	}
+	send(Notification{Kind: KindEvent, Time: time.Now(), Event: &e})
+}
+
+// Render the notification and queue it for every webhook. Never blocks the caller.
+func send(n Notification) {
+	var buf bytes.Buffer
+	if err := body.Execute(&buf, n); err != nil {
+		slog.Error("Unable to render webhook template: " + err.Error())
+		return
+	}
+
+	for _, hook := range webhooks {
+		hook.enqueue(buf.Bytes())
+	}
+}
+
+// Create a webhook and start its worker
+func newWebhook(url string, retries int, timeout time.Duration) *webhook {
+	hook := &webhook{
+		url:     url,
+		queue:   make(chan []byte, queueSize),
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		backoff: time.Second,
+	}
+	go hook.worker()
+	return hook
+}
+
+// Queue a payload for the webhook without blocking
+func (hook *webhook) enqueue(payload []byte) {
+	select {
+	case hook.queue <- payload:
+	default:
+		slog.Warn("Webhook queue full, dropping notification for: " + hook.url)
+		webhookDropped.WithLabelValues("queue_full").Inc()
+	}
+}
+
+func (hook *webhook) worker() {
+	for payload := range hook.queue {
+		if !hook.deliver(payload) {
+			slog.Error(fmt.Sprintf("Giving up on webhook post to %s after %d attempts", hook.url, hook.retries+1))
+			webhookDropped.WithLabelValues("retries").Inc()
+		}
+	}
+}
+
+// Post the payload, retrying with a backoff. Returns false if every attempt failed
+func (hook *webhook) deliver(payload []byte) bool {
+	backoff := hook.backoff
+	for attempt := 0; attempt <= hook.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff = backoff * 2
+		}
+		err := hook.post(payload)
+		if err == nil {
+			return true
+		}
+		slog.Warn(fmt.Sprintf("Webhook post to %s failed (attempt %d): %s", hook.url, attempt+1, err.Error()))
+	}
+	return false
+}
+
+func (hook *webhook) post(payload []byte) error {
+	resp, err := hook.client.Post(hook.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/alerts"
+	"github.com/cheetahfox/longping/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Records the posts and answers with status
+type receiver struct {
+	mu     sync.Mutex
+	status int
+	bodies []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bodies = append(r.bodies, string(body))
+	w.WriteHeader(r.status)
+}
+
+func (r *receiver) posts() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.bodies...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{`{{ .Kind }} {{ .Alert.Rule }} {{ .Alert.Hostname }} {{ json .Alert.Message }}`, `alert latency router1 "latency is high"`},
+		{`{"text": {{ if .Alert }}{{ json .Alert.Message }}{{ else }}{{ json .Event.Message }}{{ end }}}`, `{"text": "latency is high"}`},
+	}
+	for _, test := range tests {
+		rcv := &receiver{status: http.StatusOK}
+		srv := httptest.NewServer(rcv)
+
+		webhooks = nil
+		err := StartWebhooks(config.WebhookConfiguration{
+			Urls:     []string{srv.URL},
+			Template: test.template,
+			Timeout:  time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		Alert(alerts.Alert{Rule: "latency", Hostname: "router1", Message: "latency is high"})
+
+		waitFor(t, func() bool { return len(rcv.posts()) == 1 })
+		if got := rcv.posts()[0]; got != test.want {
+			t.Errorf("rendered %q, want %q", got, test.want)
+		}
+		srv.Close()
+	}
+	webhooks = nil
+}
+
+func TestWebhookTemplateError(t *testing.T) {
+	if err := StartWebhooks(config.WebhookConfiguration{Template: "{{ .Kind "}); err == nil {
+		t.Error("expected a bad template to fail")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	rcv := &receiver{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	hook := newWebhook(srv.URL, 2, time.Second)
+	hook.backoff = time.Millisecond
+	dropped := testutil.ToFloat64(webhookDropped.WithLabelValues("retries"))
+
+	hook.enqueue([]byte(`{}`))
+	waitFor(t, func() bool { return testutil.ToFloat64(webhookDropped.WithLabelValues("retries")) == dropped+1 })
+	// The first attempt and two retries
+	if got := len(rcv.posts()); got != 3 {
+		t.Errorf("posted %d times, want 3", got)
+	}
+
+	// Once the receiver is back the next notification goes through on the first attempt
+	rcv.mu.Lock()
+	rcv.status = http.StatusOK
+	rcv.mu.Unlock()
+	hook.enqueue([]byte(`{}`))
+	waitFor(t, func() bool { return len(rcv.posts()) == 4 })
+	time.Sleep(50 * time.Millisecond)
+	if got := len(rcv.posts()); got != 4 {
+		t.Errorf("posted %d times, want 4", got)
+	}
+	if got := testutil.ToFloat64(webhookDropped.WithLabelValues("retries")); got != dropped+1 {
+		t.Errorf("dropped %v, want %v", got, dropped+1)
+	}
+}
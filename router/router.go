@@ -1,10 +1,14 @@
 package router
 
 import (
+	"github.com/cheetahfox/longping/api"
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/exporter"
 	"github.com/cheetahfox/longping/health"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -13,6 +17,29 @@ func SetupRoutes(app *fiber.App) {
 	// Setup the routes
 	app.Get("/healthz", health.GetHealthz)
 	app.Get("/readyz", health.GetReadyz)
-	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler())) // Prometheus metrics endpoint
+	app.Get("/metrics", metricsHandler(exporter.Gatherer())) // Prometheus metrics endpoint
+	if config.Config.MetricsSplit {
+		app.Get("/metrics/probes", metricsHandler(exporter.ProbeGatherer()))
+		app.Get("/metrics/self", metricsHandler(exporter.SelfGatherer()))
+	}
 
+	// JSON API
+	v1 := app.Group("/api/v1")
+	v1.Get("/hosts", api.GetHosts)
+	v1.Get("/hosts/:host", api.GetHost)
+	if config.Config.ApiRemove {
+		v1.Delete("/hosts/:host", api.DeleteHost)
+	}
+	v1.Get("/events", api.GetEvents)
+	v1.Get("/sla", api.GetSla)
+	v1.Get("/sla/:host", api.GetHostSla)
+	v1.Get("/alerts", api.GetAlerts)
+	v1.Get("/topology", api.GetTopology)
+
+}
+
+func metricsHandler(gatherer prometheus.Gatherer) fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}),
+	))
 }
@@ -0,0 +1,233 @@
+/*
+Availability accounting over calendar periods.
+
+The packet windows only cover the last 1000 packets (~17 minutes) but SLAs are written against hours,
+days and months. So for each IP we keep hourly, daily and monthly buckets of packets sent/received, the
+time we have been monitoring and the time spent in an outage. All of the buckets are in UTC.
+*/
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+)
+
+const (
+	PeriodHourly  = "hourly"
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+// How many buckets of each period we keep around
+var periodRetention = map[string]int{
+	PeriodHourly:  48,
+	PeriodDaily:   62,
+	PeriodMonthly: 13,
+}
+
+type availabilityBucket struct {
+	Start            time.Time
+	Sent             int
+	Received         int
+	MonitoredSeconds float64
+	OutageSeconds    float64
+}
+
+// Buckets for each period, oldest first
+type availability map[string][]availabilityBucket
+
+type SlaBucket struct {
+	Start                       time.Time `json:"start"`
+	End                         time.Time `json:"end"`
+	Sent                        int       `json:"sent"`
+	Received                    int       `json:"received"`
+	MonitoredSeconds            float64   `json:"monitored_seconds"`
+	OutageSeconds               float64   `json:"outage_seconds"`
+	PacketAvailability          float64   `json:"packet_availability"`
+	Availability                float64   `json:"availability"`
+	Compliant                   bool      `json:"compliant"`
+	ErrorBudgetSeconds          float64   `json:"error_budget_seconds"`
+	ErrorBudgetRemainingSeconds float64   `json:"error_budget_remaining_seconds"`
+	ErrorBudgetRemaining        float64   `json:"error_budget_remaining"`
+}
+
+type SlaReport struct {
+	Hostname string      `json:"hostname"`
+	Ip       string      `json:"ip_address"`
+	Period   string      `json:"period"`
+	Target   float64     `json:"target"`
+	Buckets  []SlaBucket `json:"buckets"`
+}
+
+// Return a copy of the buckets that can be used without holding the ipRings lock
+func (a availability) copy() availability {
+	buckets := make(availability)
+	for period, list := range a {
+		buckets[period] = append([]availabilityBucket(nil), list...)
+	}
+	return buckets
+}
+
+// Return the start and end of the period that t falls in
+func periodBounds(period string, t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	switch period {
+	case PeriodHourly:
+		start := t.Truncate(time.Hour)
+		return start, start.Add(time.Hour)
+	case PeriodDaily:
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1)
+	default:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+/*
+Add a packet to the current bucket of each period. down is how much of the time before the packet
+was spent in an outage, which can go back further than the last packet when an outage opens. The
+time is split over the buckets it falls in so a loss run that started in the last hour doesn't
+push this hour past 100% down. Must be called with the ipRings lock held.
+*/
+func trackAvailability(p ping, pIp *ipRings, down time.Duration) {
+	if pIp.Availability == nil {
+		pIp.Availability = make(availability)
+	}
+	monitored := sinceLastSent(p, pIp)
+
+	for period, retention := range periodRetention {
+		start, _ := periodBounds(period, p.sent)
+		buckets := pIp.Availability[period]
+
+		if len(buckets) == 0 || buckets[len(buckets)-1].Start.Before(start) {
+			buckets = append(buckets, availabilityBucket{Start: start})
+			if len(buckets) > retention {
+				buckets = buckets[len(buckets)-retention:]
+			}
+		}
+
+		// Packets can show up slightly out of order so find the bucket they belong in
+		index := len(buckets) - 1
+		for index > 0 && buckets[index].Start.After(start) {
+			index--
+		}
+		bucket := &buckets[index]
+
+		bucket.Sent++
+		if p.replyReceived {
+			bucket.Received++
+		}
+		splitSeconds(buckets, period, p.sent.Add(-monitored), p.sent, func(b *availabilityBucket, seconds float64) {
+			b.MonitoredSeconds = b.MonitoredSeconds + seconds
+		})
+		// A bucket can never be down for longer than we watched it
+		splitSeconds(buckets, period, p.sent.Add(-down), p.sent, func(b *availabilityBucket, seconds float64) {
+			b.OutageSeconds = min(b.OutageSeconds+seconds, b.MonitoredSeconds)
+		})
+
+		pIp.Availability[period] = buckets
+	}
+
+	if p.sent.After(pIp.lastSent) {
+		pIp.lastSent = p.sent
+	}
+}
+
+// Split the time from-to over the buckets it falls in, time in a period we have no bucket for is dropped
+func splitSeconds(buckets []availabilityBucket, period string, from time.Time, to time.Time, add func(*availabilityBucket, float64)) {
+	for index := len(buckets) - 1; index >= 0; index-- {
+		start, end := periodBounds(period, buckets[index].Start)
+		if !end.After(from) {
+			return
+		}
+		if end.After(to) {
+			end = to
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(start) {
+			add(&buckets[index], end.Sub(start).Seconds())
+		}
+	}
+}
+
+// Build the SLA report for a bucket using the target as a percentage (99.9)
+func (b availabilityBucket) slaBucket(period string, target float64) SlaBucket {
+	start, end := periodBounds(period, b.Start)
+	report := SlaBucket{
+		Start:            start,
+		End:              end,
+		Sent:             b.Sent,
+		Received:         b.Received,
+		MonitoredSeconds: b.MonitoredSeconds,
+		OutageSeconds:    b.OutageSeconds,
+	}
+
+	if b.Sent != 0 {
+		report.PacketAvailability = float64(b.Received) / float64(b.Sent)
+	}
+	report.Availability = 1
+	if b.MonitoredSeconds != 0 {
+		report.Availability = max(1-b.OutageSeconds/b.MonitoredSeconds, 0)
+	}
+	report.Compliant = report.Availability*100 >= target
+
+	// The error budget is the downtime allowed over the whole period
+	report.ErrorBudgetSeconds = (1 - target/100) * end.Sub(start).Seconds()
+	report.ErrorBudgetRemainingSeconds = report.ErrorBudgetSeconds - b.OutageSeconds
+	if report.ErrorBudgetSeconds != 0 {
+		report.ErrorBudgetRemaining = report.ErrorBudgetRemainingSeconds / report.ErrorBudgetSeconds
+	}
+
+	return report
+}
+
+// Return the SLA reports for every IP of a host for a period, newest bucket first
+func GetSlaReports(host string, period string) []SlaReport {
+	var reports []SlaReport
+	rings, ok := GetRingHost(host)
+	if !ok {
+		return reports
+	}
+	target := config.GetSlaTarget(host)
+
+	for index := 0; index < len(rings.Ips); index++ {
+		pIp := &rings.Ips[index]
+		report := SlaReport{
+			Hostname: host,
+			Ip:       pIp.Ip.String(),
+			Period:   period,
+			Target:   target,
+			Buckets:  []SlaBucket{},
+		}
+
+		pIp.Mu.Lock()
+		buckets := pIp.Availability[period]
+		for i := len(buckets) - 1; i >= 0; i-- {
+			report.Buckets = append(report.Buckets, buckets[i].slaBucket(period, target))
+		}
+		pIp.Mu.Unlock()
+
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// Return the SLA reports for all of the hosts sorted by hostname
+func GetAllSlaReports(period string) []SlaReport {
+	var hosts []string
+	for host := range MonitoredHosts() {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	reports := []SlaReport{}
+	for _, host := range hosts {
+		reports = append(reports, GetSlaReports(host, period)...)
+	}
+	return reports
+}
@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+)
+
+// An outage that opens just after the hour is back-dated into the hour before
+func TestAvailabilityOutageAcrossHours(t *testing.T) {
+	config.Config.OutageLosses = 5
+	pIp := &ipRings{Ip: net.ParseIP("192.0.2.1")}
+
+	start := time.Date(2026, 1, 1, 12, 59, 50, 0, time.UTC)
+	for i := 0; i <= 20; i++ {
+		p := ping{sent: start.Add(time.Duration(i) * time.Second)}
+		// Everything from 12:59:57 on is lost
+		if i < 7 {
+			p.replyReceived = true
+			p.rtts = 10 * time.Millisecond
+		}
+		down := trackOutage(p, pIp, "availability.test")
+		trackAvailability(p, pIp, down)
+	}
+
+	hours := pIp.Availability[PeriodHourly]
+	if len(hours) != 2 {
+		t.Fatalf("got %d hourly buckets, want 2", len(hours))
+	}
+	tests := []struct {
+		bucket    availabilityBucket
+		monitored float64
+		outage    float64
+	}{
+		{hours[0], 10, 3},
+		{hours[1], 10, 10},
+	}
+	for _, test := range tests {
+		if test.bucket.MonitoredSeconds != test.monitored || test.bucket.OutageSeconds != test.outage {
+			t.Errorf("%s monitored %vs down %vs, want %vs and %vs", test.bucket.Start, test.bucket.MonitoredSeconds,
+				test.bucket.OutageSeconds, test.monitored, test.outage)
+		}
+		if sla := test.bucket.slaBucket(PeriodHourly, 99.9); sla.Availability < 0 || sla.Availability > 1 {
+			t.Errorf("%s availability is %v", test.bucket.Start, sla.Availability)
+		}
+	}
+	if days := pIp.Availability[PeriodDaily]; len(days) != 1 || days[0].OutageSeconds != 13 || days[0].MonitoredSeconds != 20 {
+		t.Errorf("daily bucket is %+v, want 13s down of 20s", days)
+	}
+}
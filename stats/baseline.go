@@ -0,0 +1,158 @@
+/*
+Baseline learning and latency anomaly detection.
+
+A slow creep in latency is hard to see on a graph so we learn what normal looks like for each IP. Every
+probe adds the median latency of the 1000 packet window to the current hour, and when the hour is over
+its average is folded into an EWMA for that hour of the day. That gives us a seasonal baseline (busy hours
+are allowed to be slower) along with an EWMA of how far the hour usually strays from it. Until an hour of
+the day has been learned we fall back to an EWMA across all hours.
+
+The deviation score is how many "usual deviations" the current median is above the baseline. When it is
+over the threshold for long enough we call it an anomaly and open an event.
+*/
+package stats
+
+import (
+	"container/ring"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
+)
+
+type seasonBucket struct {
+	MeanNs      float64
+	DeviationNs float64
+	Samples     int
+}
+
+type latencyBaseline struct {
+	Seasonal       [24]seasonBucket
+	Overall        seasonBucket
+	BaselineNs     float64
+	DeviationScore float64
+	Anomaly        bool
+	hour           time.Time
+	hourSum        float64
+	hourCount      int
+	exceededSince  time.Time
+	event          *events.Event
+}
+
+/*
+Return the median latency from the long term statistics
+*/
+func genMedianLatency(ring *ring.Ring) time.Duration {
+	var rtts []time.Duration
+	ringSize := ring.Len()
+	for i := 0; i < ringSize; i++ {
+		if v, ok := ring.Value.(ping); ok && v.replyReceived {
+			rtts = append(rtts, v.rtts)
+		}
+		ring = ring.Next()
+	}
+
+	if len(rtts) == 0 {
+		return 0
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+
+	middle := len(rtts) / 2
+	if len(rtts)%2 == 0 {
+		return (rtts[middle-1] + rtts[middle]) / 2
+	}
+	return rtts[middle]
+}
+
+// Fold a new sample into an EWMA bucket
+func (b *seasonBucket) add(sample float64, alpha float64) {
+	if b.Samples == 0 {
+		b.MeanNs = sample
+		b.DeviationNs = 0
+	} else {
+		b.DeviationNs = alpha*math.Abs(sample-b.MeanNs) + (1-alpha)*b.DeviationNs
+		b.MeanNs = alpha*sample + (1-alpha)*b.MeanNs
+	}
+	b.Samples++
+}
+
+/*
+Update the baseline with the current 1000 packet median and check for an anomaly. now is the
+time the probe was sent. Must be called with the ipRings lock held.
+*/
+func trackBaseline(pIp *ipRings, hostname string, now time.Time) {
+	b := &pIp.Baseline
+	median := float64(pIp.Median1000LatencyNs)
+	if median == 0 {
+		return
+	}
+
+	// Close out the last hour and fold it into the baseline
+	hour := now.Truncate(time.Hour)
+	if !hour.Equal(b.hour) {
+		if b.hourCount != 0 {
+			mean := b.hourSum / float64(b.hourCount)
+			b.Seasonal[b.hour.Hour()].add(mean, config.Config.BaselineAlpha)
+			b.Overall.add(mean, config.Config.BaselineAlpha)
+		}
+		b.hour = hour
+		b.hourSum = 0
+		b.hourCount = 0
+	}
+	b.hourSum = b.hourSum + median
+	b.hourCount++
+
+	bucket := b.Seasonal[now.Hour()]
+	if bucket.Samples == 0 {
+		bucket = b.Overall
+	}
+	// Nothing learned yet
+	if bucket.Samples == 0 {
+		b.BaselineNs = 0
+		b.DeviationScore = 0
+		return
+	}
+	b.BaselineNs = bucket.MeanNs
+
+	// Don't let a very steady link turn every microsecond into an anomaly
+	deviation := math.Max(bucket.DeviationNs, math.Max(bucket.MeanNs*0.05, float64(time.Millisecond)/10))
+	b.DeviationScore = (median - bucket.MeanNs) / deviation
+
+	if b.DeviationScore <= config.Config.AnomalyThreshold {
+		b.exceededSince = time.Time{}
+		if b.Anomaly {
+			b.Anomaly = false
+			b.event.State = events.StateClosed
+			b.event.End = now
+			b.event.Duration = now.Sub(b.event.Start)
+			b.event.Message = fmt.Sprintf("%s (%s) latency is back to the baseline of %s", hostname, pIp.Ip.String(), time.Duration(b.BaselineNs))
+			pIp.queueEvent(b.event)
+			slog.Info("Latency anomaly ended for: " + hostname + " ---> " + pIp.Ip.String())
+			b.event = nil
+		}
+		return
+	}
+
+	if b.exceededSince.IsZero() {
+		b.exceededSince = now
+	}
+	sustain := time.Duration(config.Config.AnomalySustain) * time.Second
+	if !b.Anomaly && now.Sub(b.exceededSince) >= sustain {
+		b.Anomaly = true
+		b.event = &events.Event{
+			Type:     events.TypeAnomaly,
+			State:    events.StateOpen,
+			Hostname: hostname,
+			Ip:       pIp.Ip.String(),
+			Start:    b.exceededSince,
+			Message: fmt.Sprintf("%s (%s) median latency %s is above the baseline of %s", hostname, pIp.Ip.String(),
+				pIp.Median1000LatencyNs, time.Duration(b.BaselineNs)),
+		}
+		pIp.queueEvent(b.event)
+		slog.Warn("Latency anomaly started for: " + hostname + " ---> " + pIp.Ip.String())
+	}
+}
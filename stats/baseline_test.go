@@ -0,0 +1,65 @@
+package stats
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
+)
+
+func TestBaselineAnomaly(t *testing.T) {
+	config.Config.AnomalyThreshold = 3
+	config.Config.AnomalySustain = 300
+	config.Config.BaselineAlpha = 0.2
+	pIp := &ipRings{Ip: net.ParseIP("192.0.2.30")}
+
+	// Two steady days at 20ms, one probe a minute
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	probe := func(median time.Duration) {
+		pIp.Median1000LatencyNs = median
+		trackBaseline(pIp, "baseline.test", now)
+		now = now.Add(time.Minute)
+	}
+	for now.Before(start.Add(48 * time.Hour)) {
+		probe(20 * time.Millisecond)
+	}
+	if pIp.Baseline.BaselineNs != float64(20*time.Millisecond) || pIp.Baseline.DeviationScore != 0 {
+		t.Fatalf("baseline %v score %v after a steady series", time.Duration(pIp.Baseline.BaselineNs), pIp.Baseline.DeviationScore)
+	}
+	if pending := pIp.takeEvents(); len(pending) != 0 {
+		t.Fatalf("got %d events from a steady series", len(pending))
+	}
+
+	// 25ms is 5 deviations above, the deviation has a floor of 5% of the baseline
+	for i := 0; i < 5; i++ {
+		probe(25 * time.Millisecond)
+		if math.Abs(pIp.Baseline.DeviationScore-5) > 1e-9 {
+			t.Fatalf("deviation score %v, want 5", pIp.Baseline.DeviationScore)
+		}
+	}
+	if pIp.Baseline.Anomaly || len(pIp.pending) != 0 {
+		t.Fatal("anomaly opened before it was sustained")
+	}
+	outlier := now.Add(-5 * time.Minute)
+	probe(25 * time.Millisecond)
+	pending := pIp.takeEvents()
+	if len(pending) != 1 || pending[0].Type != events.TypeAnomaly || pending[0].State != events.StateOpen {
+		t.Fatalf("got %+v, want an open anomaly", pending)
+	}
+	if !pending[0].Start.Equal(outlier) {
+		t.Errorf("anomaly started at %s, want %s", pending[0].Start, outlier)
+	}
+
+	probe(20 * time.Millisecond)
+	closed := pIp.takeEvents()
+	if len(closed) != 1 || closed[0].Id != pending[0].Id || closed[0].State != events.StateClosed {
+		t.Fatalf("got %+v, want anomaly %d closed", closed, pending[0].Id)
+	}
+	if closed[0].Duration != 6*time.Minute {
+		t.Errorf("anomaly lasted %s, want 6m", closed[0].Duration)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Loss burst analysis for the packet windows.
+
+Packet loss by itself doesn't tell us if we are losing a packet here and there or if we are losing
+5 packets in a row every 45 seconds. So for each window we walk the packets in the order they were
+sent and count the runs of consecutive lost packets. We also fit a simple two state Gilbert-Elliott
+model where the "bad" state always drops the packet; p is the chance of going from good to bad and
+r is the chance of going from bad back to good.
+*/
+package stats
+
+import (
+	"container/ring"
+	"log/slog"
+	"sort"
+	"strconv"
+)
+
+type lossBursts struct {
+	MaxBurst   int
+	MeanBurst  float64
+	BurstCount int
+	GilbertP   float64
+	GilbertR   float64
+}
+
+// Return the packets in a ring ordered by sent time, empty slots are skipped.
+func ringPackets(r *ring.Ring) []ping {
+	var packets []ping
+	ringSize := r.Len()
+	for i := 0; i < ringSize; i++ {
+		switch v := r.Value.(type) {
+		case ping:
+			packets = append(packets, v)
+		case int:
+			slog.Debug(strconv.Itoa(v))
+		default:
+		}
+		r = r.Next()
+	}
+
+	sort.SliceStable(packets, func(i, j int) bool {
+		return packets[i].sent.Before(packets[j].sent)
+	})
+	return packets
+}
+
+// Calculate the loss bursts and Gilbert-Elliott parameters for a window
+func genLossBursts(r *ring.Ring) lossBursts {
+	var bursts lossBursts
+	var run, totalLost int
+	var goodPairs, badPairs, goodToBad, badToGood int
+
+	packets := ringPackets(r)
+	for i, p := range packets {
+		if !p.replyReceived {
+			run++
+			totalLost++
+		} else if run > 0 {
+			bursts.BurstCount++
+			run = 0
+		}
+		if run > bursts.MaxBurst {
+			bursts.MaxBurst = run
+		}
+
+		// Count the state transitions between this packet and the next one
+		if i == len(packets)-1 {
+			continue
+		}
+		next := packets[i+1]
+		if p.replyReceived {
+			goodPairs++
+			if !next.replyReceived {
+				goodToBad++
+			}
+		} else {
+			badPairs++
+			if next.replyReceived {
+				badToGood++
+			}
+		}
+	}
+	// Close out a burst that runs to the end of the window
+	if run > 0 {
+		bursts.BurstCount++
+	}
+
+	if bursts.BurstCount != 0 {
+		bursts.MeanBurst = float64(totalLost) / float64(bursts.BurstCount)
+	}
+	if goodPairs != 0 {
+		bursts.GilbertP = float64(goodToBad) / float64(goodPairs)
+	}
+	// With no losses we have never been in the bad state; treat it as leaving right away
+	bursts.GilbertR = 1
+	if badPairs != 0 {
+		bursts.GilbertR = float64(badToGood) / float64(badPairs)
+	}
+
+	return bursts
+}
+
+/*
+Return the G.107 burst ratio for the window. 1 means the loss is random and anything above 1
+means the losses are burstier than random.
+*/
+func (b lossBursts) burstRatio() float64 {
+	if b.GilbertP+b.GilbertR == 0 {
+		return 1
+	}
+	return 1 / (b.GilbertP + b.GilbertR)
+}
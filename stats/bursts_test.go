@@ -0,0 +1,55 @@
+package stats
+
+import (
+	"container/ring"
+	"math"
+	"testing"
+	"time"
+)
+
+// Build a window from a pattern where x is a lost packet and . is a reply
+func patternRing(pattern string) *ring.Ring {
+	r := ring.New(len(pattern))
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, c := range pattern {
+		r.Value = ping{sent: start.Add(time.Duration(i) * time.Second), replyReceived: c != 'x'}
+		r = r.Next()
+	}
+	return r
+}
+
+func TestGenLossBursts(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    lossBursts
+		ratio   float64
+	}{
+		{"no loss", "..........", lossBursts{GilbertR: 1}, 1},
+		{"one isolated loss", "....x.....", lossBursts{MaxBurst: 1, MeanBurst: 1, BurstCount: 1, GilbertP: 1.0 / 8, GilbertR: 1}, 1 / 1.125},
+		{"one long burst", "..xxxxx...", lossBursts{MaxBurst: 5, MeanBurst: 5, BurstCount: 1, GilbertP: 1.0 / 4, GilbertR: 1.0 / 5}, 1 / 0.45},
+		{"alternating loss", "x.x.x.x.x.", lossBursts{MaxBurst: 1, MeanBurst: 1, BurstCount: 5, GilbertP: 1, GilbertR: 1}, 0.5},
+		{"all lost", "xxxxxxxxxx", lossBursts{MaxBurst: 10, MeanBurst: 10, BurstCount: 1}, 1},
+	}
+	for _, test := range tests {
+		got := genLossBursts(patternRing(test.pattern))
+		if got.MaxBurst != test.want.MaxBurst || got.BurstCount != test.want.BurstCount || got.MeanBurst != test.want.MeanBurst {
+			t.Errorf("%s: max %d count %d mean %v, want max %d count %d mean %v", test.name,
+				got.MaxBurst, got.BurstCount, got.MeanBurst, test.want.MaxBurst, test.want.BurstCount, test.want.MeanBurst)
+		}
+		if math.Abs(got.GilbertP-test.want.GilbertP) > 1e-9 || math.Abs(got.GilbertR-test.want.GilbertR) > 1e-9 {
+			t.Errorf("%s: p %v r %v, want p %v r %v", test.name, got.GilbertP, got.GilbertR, test.want.GilbertP, test.want.GilbertR)
+		}
+		if ratio := got.burstRatio(); math.Abs(ratio-test.ratio) > 1e-9 {
+			t.Errorf("%s: burst ratio %v, want %v", test.name, ratio, test.ratio)
+		}
+	}
+}
+
+// The ring is walked in sent order no matter where it starts
+func TestGenLossBurstsRingOrder(t *testing.T) {
+	r := patternRing("xx........").Move(5)
+	if got := genLossBursts(r); got.BurstCount != 1 || got.MaxBurst != 2 {
+		t.Errorf("got %d bursts of up to %d, want 1 of 2", got.BurstCount, got.MaxBurst)
+	}
+}
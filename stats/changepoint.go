@@ -0,0 +1,165 @@
+/*
+Change-point detection on the latency and loss series.
+
+We run a two sided CUSUM over the RTT of every probe and over the loss rate of back to back blocks of
+lossBlockSize packets. Each detector first learns the level and usual deviation of the series, then
+adds up how far each new sample is from that level in deviations (less the drift allowance). When
+either sum goes over the threshold the series has stepped to a new level and we record a change event
+with the level before and after the step. The detector then starts learning again from the new level.
+
+Each sample is clamped to half the threshold so a single spike can't trigger a change on its own.
+*/
+package stats
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
+)
+
+const (
+	lossBlockSize = 100
+	latencyWarmup = 60
+	lossWarmup    = 5
+)
+
+type cusum struct {
+	Mean      float64
+	Deviation float64
+	warmup    int
+	minDev    float64
+	samples   int
+	pos       float64
+	neg       float64
+	posStart  time.Time
+	negStart  time.Time
+	posSum    float64
+	negSum    float64
+	posCount  int
+	negCount  int
+}
+
+// A detected step in a series
+type changePoint struct {
+	At     time.Time
+	Before float64
+	After  float64
+}
+
+func newCusum(warmup int, minDev float64) cusum {
+	return cusum{warmup: warmup, minDev: minDev}
+}
+
+// Start learning the series again from a new level
+func (c *cusum) reset(mean float64) {
+	c.Mean = mean
+	c.Deviation = 0
+	c.samples = 0
+	c.pos, c.neg = 0, 0
+	c.posSum, c.negSum = 0, 0
+	c.posCount, c.negCount = 0, 0
+}
+
+// Add a sample to the detector and return the change point if the series has stepped
+func (c *cusum) add(x float64, t time.Time) (changePoint, bool) {
+	// Learn the level and deviation with a running average
+	if c.samples < c.warmup {
+		c.samples++
+		if c.samples == 1 && c.Mean == 0 {
+			c.Mean = x
+		}
+		c.Deviation = c.Deviation + (math.Abs(x-c.Mean)-c.Deviation)/float64(c.samples)
+		c.Mean = c.Mean + (x-c.Mean)/float64(c.samples)
+		return changePoint{}, false
+	}
+
+	threshold := config.Config.ChangeThreshold
+	deviation := math.Max(c.Deviation, math.Max(c.minDev, c.Mean*0.02))
+	z := (x - c.Mean) / deviation
+	z = math.Max(-threshold/2, math.Min(threshold/2, z))
+
+	c.pos = c.pos + z - config.Config.ChangeDrift
+	if c.pos <= 0 {
+		c.pos, c.posSum, c.posCount = 0, 0, 0
+	} else {
+		if c.posCount == 0 {
+			c.posStart = t
+		}
+		c.posSum = c.posSum + x
+		c.posCount++
+	}
+
+	c.neg = c.neg - z - config.Config.ChangeDrift
+	if c.neg <= 0 {
+		c.neg, c.negSum, c.negCount = 0, 0, 0
+	} else {
+		if c.negCount == 0 {
+			c.negStart = t
+		}
+		c.negSum = c.negSum + x
+		c.negCount++
+	}
+
+	var change changePoint
+	switch {
+	case c.pos > threshold:
+		change = changePoint{At: c.posStart, Before: c.Mean, After: c.posSum / float64(c.posCount)}
+	case c.neg > threshold:
+		change = changePoint{At: c.negStart, Before: c.Mean, After: c.negSum / float64(c.negCount)}
+	default:
+		return changePoint{}, false
+	}
+
+	c.reset(change.After)
+	return change, true
+}
+
+/*
+Feed a packet to the change detectors for the IP and record any steps we find. Must be called
+with the ipRings lock held.
+*/
+func trackChanges(p ping, pIp *ipRings, hostname string) {
+	if p.replyReceived {
+		ms := float64(p.rtts) / float64(time.Millisecond)
+		if change, ok := pIp.latencyChange.add(ms, p.sent); ok {
+			recordChange(change, pIp, hostname, "latency",
+				fmt.Sprintf("latency stepped from %.1fms to %.1fms at %s", change.Before, change.After, change.At.UTC().Format(time.RFC3339)))
+		}
+	}
+
+	// Loss is looked at in blocks since a single lost packet is all or nothing
+	pIp.lossBlockSent++
+	if !p.replyReceived {
+		pIp.lossBlockLost++
+	}
+	if pIp.lossBlockSent < lossBlockSize {
+		return
+	}
+	loss := float64(pIp.lossBlockLost) / float64(pIp.lossBlockSent)
+	pIp.lossBlockSent, pIp.lossBlockLost = 0, 0
+
+	if change, ok := pIp.lossChange.add(loss, p.sent); ok {
+		recordChange(change, pIp, hostname, "packetloss",
+			fmt.Sprintf("packet loss stepped from %.2f%% to %.2f%% at %s", change.Before*100, change.After*100, change.At.UTC().Format(time.RFC3339)))
+	}
+}
+
+func recordChange(change changePoint, pIp *ipRings, hostname string, metric string, message string) {
+	pIp.queueEvent(&events.Event{
+		Type:     events.TypeChange,
+		State:    events.StateClosed,
+		Hostname: hostname,
+		Ip:       pIp.Ip.String(),
+		Start:    change.At,
+		End:      change.At,
+		Metric:   metric,
+		Before:   change.Before,
+		After:    change.After,
+		Message:  hostname + " (" + pIp.Ip.String() + ") " + message,
+	})
+	slog.Info("Change detected for: " + hostname + " ---> " + pIp.Ip.String() + " " + message)
+}
@@ -0,0 +1,110 @@
+package stats
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
+)
+
+// Collect the events of one host
+func captureEvents(host string) func() []events.Event {
+	var mu sync.Mutex
+	var captured []events.Event
+	events.Subscribe(func(e events.Event) {
+		if e.Hostname != host {
+			return
+		}
+		mu.Lock()
+		captured = append(captured, e)
+		mu.Unlock()
+	})
+	return func() []events.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]events.Event(nil), captured...)
+	}
+}
+
+func TestCusumLatencyStep(t *testing.T) {
+	config.Config.ChangeThreshold = 8
+	config.Config.ChangeDrift = 1
+	c := newCusum(latencyWarmup, 0.1)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var found []changePoint
+	for i := 0; i < 300; i++ {
+		// 20ms with a little noise, stepping up to 45ms after 200 samples
+		ms := 20 + float64(i%3)*0.5
+		if i >= 200 {
+			ms = ms + 25
+		}
+		if change, ok := c.add(ms, start.Add(time.Duration(i)*time.Second)); ok {
+			found = append(found, change)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("found %d changes, want 1: %+v", len(found), found)
+	}
+	change := found[0]
+	if change.Before < 20 || change.Before > 21 || change.After < 45 || change.After > 46 {
+		t.Errorf("stepped from %v to %v, want about 20 to 45", change.Before, change.After)
+	}
+	if want := start.Add(200 * time.Second); !change.At.Equal(want) {
+		t.Errorf("step at %s, want %s", change.At, want)
+	}
+}
+
+// Loss going from none to some is the most common change, the 0 before it has to make it out
+func TestChangeLossStepFromZero(t *testing.T) {
+	config.Config.ChangeThreshold = 8
+	config.Config.ChangeDrift = 1
+	pIp := &ipRings{Ip: net.ParseIP("192.0.2.10"), lossChange: newCusum(lossWarmup, 0.01), latencyChange: newCusum(latencyWarmup, 0.1)}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20*lossBlockSize; i++ {
+		// No loss for 10 blocks then every 10th packet is lost
+		p := ping{sent: start.Add(time.Duration(i) * time.Second), replyReceived: true, rtts: 20 * time.Millisecond}
+		if i >= 10*lossBlockSize && i%10 == 0 {
+			p.replyReceived = false
+		}
+		trackChanges(p, pIp, "change.test")
+	}
+
+	var loss []events.Event
+	for _, e := range pIp.takeEvents() {
+		if e.Metric == "packetloss" {
+			loss = append(loss, e)
+		}
+	}
+	if len(loss) != 1 {
+		t.Fatalf("got %d loss changes, want 1", len(loss))
+	}
+	if loss[0].Before != 0 || math.Abs(loss[0].After-0.1) > 1e-9 {
+		t.Errorf("stepped from %v to %v, want 0 to 0.1", loss[0].Before, loss[0].After)
+	}
+	if !strings.Contains(loss[0].Message, "2026-01-01T") || !strings.HasSuffix(loss[0].Message, "Z") {
+		t.Errorf("message %q doesn't have the date and zone", loss[0].Message)
+	}
+
+	body, err := json.Marshal(loss[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if before, ok := decoded["before"]; !ok || before != 0.0 {
+		t.Errorf("before is %v in %s", before, body)
+	}
+	if after, ok := decoded["after"].(float64); !ok || math.Abs(after-0.1) > 1e-9 {
+		t.Errorf("after is %v in %s", after, body)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Flap detection for IPs that keep bouncing between up and down.
+
+An IP counts as down once it has lost FlapLosses packets in a row and up again with the first reply,
+fewer losses than it takes to open an outage so a link bouncing every few seconds is still caught.
+Every time it goes down or comes back up we note the time. If there have been FlapThreshold or more
+changes within the last FlapWindow seconds we call the IP flapping and open a flapping event. It
+stops flapping once the changes in the window drop to half of the threshold, so a link has to
+settle down for a while before it is considered stable again.
+*/
+package stats
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
+)
+
+/*
+Track the up/down changes for an IP. Must be called with the ipRings lock held, after trackOutage
+has counted the packet in the loss run.
+*/
+func trackFlapping(p ping, pIp *ipRings, hostname string) {
+	now := p.sent
+	down := pIp.LossRun >= config.Config.FlapLosses
+	if down != pIp.flapDown {
+		pIp.flapDown = down
+		pIp.stateChanges = append(pIp.stateChanges, now)
+	}
+
+	window := time.Duration(config.Config.FlapWindow) * time.Second
+	drop := 0
+	for drop < len(pIp.stateChanges) && now.Sub(pIp.stateChanges[drop]) > window {
+		drop++
+	}
+	pIp.stateChanges = pIp.stateChanges[drop:]
+	pIp.StateChanges = len(pIp.stateChanges)
+
+	if !pIp.Flapping && pIp.StateChanges >= config.Config.FlapThreshold {
+		pIp.Flapping = true
+		pIp.flapping = &events.Event{
+			Type:     events.TypeFlapping,
+			State:    events.StateOpen,
+			Hostname: hostname,
+			Ip:       pIp.Ip.String(),
+			Start:    pIp.stateChanges[0],
+			Message: fmt.Sprintf("%s (%s) is flapping, %d up/down changes in %s", hostname, pIp.Ip.String(),
+				pIp.StateChanges, window),
+		}
+		pIp.queueEvent(pIp.flapping)
+		slog.Warn("Flapping started for: " + hostname + " ---> " + pIp.Ip.String())
+		return
+	}
+
+	if pIp.Flapping && pIp.StateChanges <= config.Config.FlapThreshold/2 {
+		pIp.Flapping = false
+		pIp.flapping.State = events.StateClosed
+		pIp.flapping.End = now
+		pIp.flapping.Duration = now.Sub(pIp.flapping.Start)
+		state := "up"
+		if pIp.outage != nil {
+			state = "down"
+		}
+		pIp.flapping.Message = fmt.Sprintf("%s (%s) has stopped flapping and is %s", hostname, pIp.Ip.String(), state)
+		pIp.queueEvent(pIp.flapping)
+		slog.Info("Flapping ended for: " + hostname + " ---> " + pIp.Ip.String())
+		pIp.flapping = nil
+	}
+}
+
+// Check if an IP of a host is flapping
+func IpFlapping(host string, ip string) bool {
+	rings, ok := GetRingHost(host)
+	if !ok {
+		return false
+	}
+	for index := 0; index < len(rings.Ips); index++ {
+		if rings.Ips[index].Ip.String() != ip {
+			continue
+		}
+		rings.Ips[index].Mu.Lock()
+		defer rings.Ips[index].Mu.Unlock()
+		return rings.Ips[index].Flapping
+	}
+	return false
+}
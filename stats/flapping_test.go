@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+)
+
+// A link that drops a few packets every few seconds flaps without ever opening an outage
+func TestFlappingWithoutOutage(t *testing.T) {
+	config.Config.OutageLosses = 5
+	config.Config.FlapLosses = 2
+	config.Config.FlapWindow = 600
+	config.Config.FlapThreshold = 6
+	pIp := &ipRings{Ip: net.ParseIP("192.0.2.2")}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		// 3 lost then 3 replies, over and over
+		p := ping{sent: start.Add(time.Duration(i) * time.Second), replyReceived: i%6 >= 3}
+		trackOutage(p, pIp, "flapping.test")
+		trackFlapping(p, pIp, "flapping.test")
+		if pIp.outage != nil {
+			t.Fatal("an outage was opened")
+		}
+	}
+	if !pIp.Flapping {
+		t.Errorf("not flapping after %d state changes", pIp.StateChanges)
+	}
+
+	// A single lost packet now and then isn't a state change
+	pIp = &ipRings{Ip: net.ParseIP("192.0.2.3")}
+	for i := 0; i < 30; i++ {
+		p := ping{sent: start.Add(time.Duration(i) * time.Second), replyReceived: i%2 == 0}
+		trackOutage(p, pIp, "flapping.test")
+		trackFlapping(p, pIp, "flapping.test")
+	}
+	if pIp.StateChanges != 0 || pIp.Flapping {
+		t.Errorf("random loss counted as %d state changes", pIp.StateChanges)
+	}
+}
@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
+)
+
+/*
+Track runs of lost packets for an IP and open an outage event once we have lost OutageLosses
+packets in a row. The outage is closed by the first packet that gets a reply. Must be called
+with the ipRings lock held and with the packets in the order they were sent.
+
+Returns how much of the time before this packet should be counted as down for availability, when
+the outage opens that goes back to the first lost packet.
+*/
+func trackOutage(p ping, pIp *ipRings, hostname string) time.Duration {
+	var down time.Duration
+
+	if !p.replyReceived {
+		if pIp.LossRun == 0 {
+			pIp.lossRunStart = p.sent
+		}
+		pIp.LossRun++
+
+		if pIp.outage == nil && pIp.LossRun >= config.Config.OutageLosses {
+			pIp.outage = &events.Event{
+				Type:        events.TypeOutage,
+				State:       events.StateOpen,
+				Hostname:    hostname,
+				Ip:          pIp.Ip.String(),
+				Start:       pIp.lossRunStart,
+				PacketsLost: pIp.LossRun,
+				Message:     fmt.Sprintf("%s (%s) is unreachable", hostname, pIp.Ip.String()),
+				CausedBy:    causedBy(hostname, pIp.lossRunStart),
+				Flapping:    pIp.Flapping,
+			}
+			if pIp.outage.CausedBy != "" {
+				pIp.outage.Message = pIp.outage.Message + " caused by parent " + pIp.outage.CausedBy
+			}
+			markOutageStart(hostname, pIp.lossRunStart)
+			pIp.queueEvent(pIp.outage)
+			slog.Warn("Outage started for: " + hostname + " ---> " + pIp.Ip.String())
+
+			// The outage really started with the first lost packet
+			return p.sent.Sub(pIp.lossRunStart)
+		}
+		if pIp.outage != nil {
+			pIp.outage.PacketsLost = pIp.LossRun
+			down = sinceLastSent(p, pIp)
+		}
+		return down
+	}
+
+	if pIp.outage != nil {
+		down = sinceLastSent(p, pIp)
+		pIp.outage.State = events.StateClosed
+		pIp.outage.End = p.sent
+		pIp.outage.Duration = p.sent.Sub(pIp.outage.Start)
+		pIp.outage.Message = fmt.Sprintf("%s (%s) recovered after %s", hostname, pIp.Ip.String(), pIp.outage.Duration)
+		// The parent may have noticed its outage after we did
+		if pIp.outage.CausedBy == "" {
+			pIp.outage.CausedBy = causedBy(hostname, pIp.outage.Start)
+		}
+		if pIp.outage.CausedBy != "" {
+			pIp.outage.Message = pIp.outage.Message + " caused by parent " + pIp.outage.CausedBy
+		}
+		pIp.outage.Flapping = pIp.Flapping
+		markOutageEnd(hostname, p.sent)
+		pIp.queueEvent(pIp.outage)
+		slog.Warn("Outage ended for: " + hostname + " ---> " + pIp.Ip.String() + " after " + pIp.outage.Duration.String())
+		pIp.outage = nil
+	}
+	pIp.LossRun = 0
+	return down
+}
+
+// Time between this packet and the last packet we sent to the IP
+func sinceLastSent(p ping, pIp *ipRings) time.Duration {
+	if pIp.lastSent.IsZero() || p.sent.Before(pIp.lastSent) {
+		return 0
+	}
+	return p.sent.Sub(pIp.lastSent)
+}
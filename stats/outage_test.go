@@ -0,0 +1,108 @@
+package stats
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
+	probing "github.com/prometheus-community/pro-bing"
+)
+
+// Run a single packet probe through ringParseStats the way pingThread does
+func probeOnce(pIp *ipRings, hostname string, sent time.Time, reply bool) {
+	log := &probeLog{packets: map[int]*ping{0: {sent: sent, replyReceived: reply}}}
+	s := probing.Statistics{PacketsSent: 1}
+	if reply {
+		log.packets[0].rtts = 10 * time.Millisecond
+		s.PacketsRecv = 1
+		s.Rtts = []time.Duration{10 * time.Millisecond}
+	}
+	_, pending := ringParseStats(s, log, pIp, hostname, sent)
+	for _, e := range pending {
+		events.Record(e)
+	}
+}
+
+func TestOutageStartEnd(t *testing.T) {
+	config.Config.OutageLosses = 5
+	events.SetHistorySize(3)
+	t.Cleanup(func() { events.SetHistorySize(1000) })
+
+	rings := newRingStats("outage.test", []net.IP{net.ParseIP("192.0.2.20")})
+	pIp := &rings.Ips[0]
+	captured := captureEvents("outage.test")
+	// The subscribers must never be called with the ipRings lock held
+	locked := false
+	events.Subscribe(func(e events.Event) {
+		if e.Hostname != "outage.test" {
+			return
+		}
+		if !pIp.Mu.TryLock() {
+			locked = true
+			return
+		}
+		pIp.Mu.Unlock()
+	})
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sent := 0
+	probe := func(reply bool) {
+		probeOnce(pIp, "outage.test", start.Add(time.Duration(sent)*time.Second), reply)
+		sent++
+	}
+
+	probe(true)
+	for i := 0; i < 4; i++ {
+		probe(false)
+	}
+	if len(captured()) != 0 {
+		t.Fatalf("outage opened after 4 losses")
+	}
+	probe(false)
+	got := captured()
+	if len(got) != 1 || got[0].Type != events.TypeOutage || got[0].State != events.StateOpen {
+		t.Fatalf("got %+v after 5 losses, want an open outage", got)
+	}
+	if !got[0].Start.Equal(start.Add(time.Second)) {
+		t.Errorf("outage started at %s, want the first lost packet", got[0].Start)
+	}
+
+	for i := 0; i < 3; i++ {
+		probe(false)
+	}
+	probe(true)
+	got = captured()
+	if len(got) != 2 {
+		t.Fatalf("got %d events, want the outage to be updated once", len(got))
+	}
+	closed := got[1]
+	if closed.Id != got[0].Id || closed.State != events.StateClosed {
+		t.Fatalf("got %+v, want outage %d closed", closed, got[0].Id)
+	}
+	if closed.PacketsLost != 8 {
+		t.Errorf("lost %d packets, want 8", closed.PacketsLost)
+	}
+	if closed.Duration != 8*time.Second {
+		t.Errorf("outage lasted %s, want 8s", closed.Duration)
+	}
+	if locked {
+		t.Error("a subscriber was called with the ipRings lock held")
+	}
+
+	// Only the newest outages are kept
+	for outage := 0; outage < 5; outage++ {
+		for i := 0; i < 5; i++ {
+			probe(false)
+		}
+		probe(true)
+	}
+	list := events.List()
+	if len(list) != 3 {
+		t.Fatalf("history has %d events, want 3", len(list))
+	}
+	if list[0].Id <= list[2].Id || list[0].State != events.StateClosed {
+		t.Errorf("history isn't the newest events first: %+v", list)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Save the ring state to disk so a restart doesn't throw away the long term windows.
+
+We write a gob snapshot of every ring along with the totals, the availability buckets and the learned
+latency baseline for each IP. On startup the snapshot is loaded if it isn't older than the max age and
+each IP that is still resolved for a host picks up where it left off. Everything computed from the
+rings is worked out again on the next probe.
+*/
+package stats
+
+import (
+	"container/ring"
+	"context"
+	"encoding/gob"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type savedPing struct {
+	Rtt      time.Duration
+	Sent     time.Time
+	Received time.Time
+	Reply    bool
+}
+
+type ipSnapshot struct {
+	Ip              string
+	Stats15         []savedPing
+	Stats100        []savedPing
+	Stats1k         []savedPing
+	TotalSent       int
+	TotalReceived   int
+	TotalLoss       int
+	TotalDuplicates int
+	Availability    availability
+	Seasonal        [24]seasonBucket
+	Overall         seasonBucket
+}
+
+type snapshot struct {
+	Saved time.Time
+	Hosts map[string][]ipSnapshot
+}
+
+var (
+	snapshotMu sync.Mutex
+	restored   map[string][]ipSnapshot
+)
+
+func savePings(r *ring.Ring) []savedPing {
+	var saved []savedPing
+	for _, p := range ringPackets(r) {
+		saved = append(saved, savedPing{Rtt: p.rtts, Sent: p.sent, Received: p.received, Reply: p.replyReceived})
+	}
+	return saved
+}
+
+func restorePings(saved []savedPing, r *ring.Ring, hostname string) {
+	for _, s := range saved {
+		err := ringAddStats(ping{rtts: s.Rtt, sent: s.Sent, received: s.Received, replyReceived: s.Reply}, r)
+		if err != nil {
+			slog.Warn("Unable to restore packet for: " + hostname + " " + err.Error())
+		}
+	}
+}
+
+func saveIp(pIp *ipRings) ipSnapshot {
+	pIp.Mu.Lock()
+	defer pIp.Mu.Unlock()
+	return ipSnapshot{
+		Ip:              pIp.Ip.String(),
+		Stats15:         savePings(pIp.Stats15),
+		Stats100:        savePings(pIp.Stats100),
+		Stats1k:         savePings(pIp.Stats1k),
+		TotalSent:       pIp.TotalSent,
+		TotalReceived:   pIp.TotalReceived,
+		TotalLoss:       pIp.TotalLoss,
+		TotalDuplicates: pIp.TotalDuplicates,
+		Availability:    pIp.Availability.copy(),
+		Seasonal:        pIp.Baseline.Seasonal,
+		Overall:         pIp.Baseline.Overall,
+	}
+}
+
+// Write a snapshot of every host to the file, the file is replaced in one go so it's never half written.
+func SaveSnapshot(file string) error {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	snap := snapshot{Saved: time.Now(), Hosts: make(map[string][]ipSnapshot)}
+	for host, rings := range MonitoredHosts() {
+		for index := 0; index < len(rings.Ips); index++ {
+			snap.Hosts[host] = append(snap.Hosts[host], saveIp(&rings.Ips[index]))
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+/*
+Load a snapshot to be restored as the hosts are registered. Snapshots older than maxAge are
+ignored since the windows would be mostly stale anyway. Must be called before RegisterRingHost.
+*/
+func LoadSnapshot(file string, maxAge time.Duration) error {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Info("No snapshot to restore at: " + file)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return err
+	}
+
+	age := time.Since(snap.Saved)
+	if age > maxAge {
+		slog.Info("Snapshot is too old to restore: " + age.Round(time.Second).String())
+		return nil
+	}
+
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	restored = snap.Hosts
+	slog.Info("Loaded snapshot from " + age.Round(time.Second).String() + " ago")
+	return nil
+}
+
+// Restore the saved state for an IP if we have one. Called while registering the host.
+func restoreIp(host string, pIp *ipRings) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	for _, saved := range restored[host] {
+		if saved.Ip != pIp.Ip.String() {
+			continue
+		}
+		applySnapshot(host, pIp, saved)
+		return
+	}
+}
+
+// Drop the loaded snapshot once the hosts have been registered, hosts added later start fresh.
+func ForgetSnapshot() {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	restored = nil
+}
+
+// Put the saved windows and totals back into the rings of the IP
+func applySnapshot(host string, pIp *ipRings, saved ipSnapshot) {
+	restorePings(saved.Stats15, pIp.Stats15, host)
+	restorePings(saved.Stats100, pIp.Stats100, host)
+	restorePings(saved.Stats1k, pIp.Stats1k, host)
+	pIp.TotalSent = saved.TotalSent
+	pIp.TotalReceived = saved.TotalReceived
+	pIp.TotalLoss = saved.TotalLoss
+	pIp.TotalDuplicates = saved.TotalDuplicates
+	pIp.Availability = saved.Availability
+	pIp.Baseline.Seasonal = saved.Seasonal
+	pIp.Baseline.Overall = saved.Overall
+	slog.Debug("Restored snapshot for: " + host + " ---> " + pIp.Ip.String())
+}
+
+// Save a snapshot every interval until ctx is cancelled.
+func SnapshotWriter(ctx context.Context, file string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := SaveSnapshot(file); err != nil {
+			slog.Error("Unable to save snapshot: " + err.Error())
+		}
+	}
+}
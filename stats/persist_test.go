@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"container/ring"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "longping.snapshot")
+	host := "snapshot.test"
+	ip := net.ParseIP("192.0.2.40")
+
+	saved := newRingStats(host, []net.IP{ip})
+	pIp := &saved.Ips[0]
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 150; i++ {
+		p := ping{sent: start.Add(time.Duration(i) * time.Second)}
+		if i%10 != 0 {
+			p.replyReceived = true
+			p.rtts = time.Duration(10+i%5) * time.Millisecond
+		}
+		p.received = p.sent.Add(p.rtts)
+		for _, r := range []*ring.Ring{pIp.Stats15, pIp.Stats100, pIp.Stats1k} {
+			if err := ringAddStats(p, r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		trackAvailability(p, pIp, 0)
+	}
+	pIp.TotalSent, pIp.TotalReceived, pIp.TotalLoss, pIp.TotalDuplicates = 150, 135, 15, 2
+
+	ringHostsMu.Lock()
+	ringHosts[host] = saved
+	ringHostsMu.Unlock()
+	err := SaveSnapshot(file)
+	ringHostsMu.Lock()
+	delete(ringHosts, host)
+	ringHostsMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadSnapshot(file, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newRingStats(host, []net.IP{ip})
+	restoreIp(host, &loaded.Ips[0])
+	ForgetSnapshot()
+
+	want, got := saveIp(pIp), saveIp(&loaded.Ips[0])
+	if len(got.Stats15) != 15 || len(got.Stats100) != 100 || len(got.Stats1k) != 150 {
+		t.Fatalf("restored %d/%d/%d packets, want 15/100/150", len(got.Stats15), len(got.Stats100), len(got.Stats1k))
+	}
+	if !reflect.DeepEqual(got.Stats15, want.Stats15) || !reflect.DeepEqual(got.Stats100, want.Stats100) ||
+		!reflect.DeepEqual(got.Stats1k, want.Stats1k) {
+		t.Error("restored rings don't match the saved rings")
+	}
+	if got.TotalSent != 150 || got.TotalReceived != 135 || got.TotalLoss != 15 || got.TotalDuplicates != 2 {
+		t.Errorf("restored totals %d/%d/%d/%d", got.TotalSent, got.TotalReceived, got.TotalLoss, got.TotalDuplicates)
+	}
+	if len(got.Availability[PeriodHourly]) == 0 || !reflect.DeepEqual(got.Availability, want.Availability) {
+		t.Errorf("restored availability %+v, want %+v", got.Availability, want.Availability)
+	}
+
+	// Hosts registered after startup don't pick up the snapshot
+	fresh := newRingStats(host, []net.IP{ip})
+	restoreIp(host, &fresh.Ips[0])
+	if fresh.Ips[0].TotalSent != 0 {
+		t.Error("snapshot restored after it was forgotten")
+	}
+
+	// A snapshot older than the max age is ignored
+	if err := LoadSnapshot(file, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	if restored != nil {
+		t.Error("loaded a snapshot older than the max age")
+	}
+}
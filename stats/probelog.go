@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	probing "github.com/prometheus-community/pro-bing"
+)
+
+/*
+The pinger Statistics don't tell us when each packet was sent or anything about the reply, so we
+also hook the send and receive callbacks and keep the real sent time, TTL and sequence number of
+every packet in the probe.
+*/
+type probeLog struct {
+	mu      sync.Mutex
+	packets map[int]*ping
+}
+
+func newProbeLog(pinger *probing.Pinger) *probeLog {
+	log := &probeLog{packets: make(map[int]*ping)}
+	pinger.OnSend = log.sent
+	pinger.OnRecv = log.received
+	return log
+}
+
+func (log *probeLog) sent(pkt *probing.Packet) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.packets[pkt.Seq] = &ping{sent: time.Now(), seq: pkt.Seq}
+}
+
+func (log *probeLog) received(pkt *probing.Packet) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	p, ok := log.packets[pkt.Seq]
+	if !ok {
+		return
+	}
+	p.rtts = pkt.Rtt
+	p.received = p.sent.Add(pkt.Rtt)
+	p.replyReceived = true
+	p.ttl = pkt.TTL
+}
+
+// Return the packets in the order they were sent, lost packets are given the probe timeout
+func (log *probeLog) pings() []ping {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	var packets []ping
+	for _, p := range log.packets {
+		if !p.replyReceived {
+			p.received = p.sent.Add(time.Duration(config.Config.ProbeTimeout) * time.Second)
+		}
+		packets = append(packets, *p)
+	}
+	sort.Slice(packets, func(i, j int) bool {
+		return packets[i].seq < packets[j].seq
+	})
+	return packets
+}
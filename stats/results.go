@@ -0,0 +1,66 @@
+/*
+Hand the result of every probe off to whoever wants it.
+
+After each probe is added to the rings the packets it sent and a summary of the updated windows are
+passed to the subscribers, which is how the exporters get their data without stats needing to know
+anything about them.
+*/
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// A single packet sent by a probe
+type Packet struct {
+	Sent     time.Time
+	Received time.Time
+	Rtt      time.Duration
+	Reply    bool
+	Seq      int
+	Ttl      int
+}
+
+// The packets from a single probe along with the windows after they were added
+type ProbeResult struct {
+	Hostname string
+	Ip       string
+	Packets  []Packet
+	Summary  IpSummary
+}
+
+var (
+	resultsMu   sync.Mutex
+	subscribers []func(ProbeResult)
+)
+
+// Register a function to be called with the result of every probe.
+func SubscribeProbes(handler func(ProbeResult)) {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	subscribers = append(subscribers, handler)
+}
+
+// Pass the probe on to the subscribers, must be called without the ipRings lock held.
+func publishProbe(hostname string, pIp *ipRings, pings []ping) {
+	resultsMu.Lock()
+	handlers := subscribers
+	resultsMu.Unlock()
+	if len(handlers) == 0 {
+		return
+	}
+
+	result := ProbeResult{
+		Hostname: hostname,
+		Ip:       pIp.Ip.String(),
+		Summary:  pIp.summary(),
+	}
+	for _, p := range pings {
+		result.Packets = append(result.Packets, Packet{Sent: p.sent, Received: p.received, Rtt: p.rtts, Reply: p.replyReceived, Seq: p.seq, Ttl: p.ttl})
+	}
+
+	for _, handler := range handlers {
+		handler(result)
+	}
+}
@@ -8,6 +8,7 @@ package stats
 
 import (
 	"container/ring"
+	"context"
 	"errors"
 	"log/slog"
 	"math"
@@ -18,6 +19,7 @@ import (
 	"time"
 
 	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
 
 	probing "github.com/prometheus-community/pro-bing"
 )
@@ -48,65 +50,142 @@ type ipRings struct {
 	Jitter1000LatencyNs time.Duration
 	Jitter100LatencyNs  time.Duration
 	Jitter15LatencyNs   time.Duration
-	shutdown            chan bool
+	Median1000LatencyNs time.Duration
+	Codec               string
+	RFactor1000         float64
+	RFactor100          float64
+	RFactor15           float64
+	Mos1000             float64
+	Mos100              float64
+	Mos15               float64
+	LossBursts1000      lossBursts
+	LossBursts100       lossBursts
+	LossBursts15        lossBursts
+	LossRun             int
+	lossRunStart        time.Time
+	outage              *events.Event
+	Availability        availability
+	lastSent            time.Time
+	TimeWindows         []timeWindow
+	Baseline            latencyBaseline
+	latencyChange       cusum
+	lossChange          cusum
+	lossBlockSent       int
+	lossBlockLost       int
+	Flapping            bool
+	StateChanges        int
+	stateChanges        []time.Time
+	flapDown            bool
+	flapping            *events.Event
+	// Events waiting to be recorded once the lock is released
+	pending []events.Event
 }
 
 type RingStats struct {
 	Hostname string
 	Ips      []ipRings
+	// Stops and waits for the ping threads of just this host
+	cancel  context.CancelFunc
+	threads sync.WaitGroup
 }
 
-var RingHosts map[string]*RingStats
+/*
+The hosts we are monitoring. Hosts can come and go while we are running so always go through
+GetRingHost or MonitoredHosts. The Ips of a host never change once it is registered, if the
+addresses change the whole host is replaced.
+*/
+var (
+	ringHostsMu sync.RWMutex
+	ringHosts   map[string]*RingStats
+)
+
+// Every running ping thread so shutdown can wait for them to finish
+var probes sync.WaitGroup
 
 /*
 Add a new Ring Host for monitoring; we don't lock it since we aren't messing with the ring
 We do DNS resolution and for each IP address we find we are going to init a stats ring for
-our default packet windows for he last 15, 100 and 1k packets.
+our default packet windows for he last 15, 100 and 1k packets. The ping threads run until ctx is cancelled
+or the host is removed.
 */
-func RegisterRingHost(host string) error {
-
-	stats := new(RingStats)
-	RingHosts[host] = stats
-
-	RingHosts[host].Hostname = host
-
+func RegisterRingHost(ctx context.Context, host string) error {
 	ips, err := net.LookupIP(host)
 	if err != nil {
-		delete(RingHosts, host) // If we can't resolve the host, remove it from the map
 		return err
 	}
 
-	for _, ip := range ips {
-		var newRing ipRings
-		newRing.Ip = ip
-		newRing.Stats1k = ring.New(1000)
-		newRing.Stats100 = ring.New(100)
-		newRing.Stats15 = ring.New(15)
-
-		// Here we don't care that we are copying a struct with a mutex because this is the initialization of the ring.
-		RingHosts[host].Ips = append(RingHosts[host].Ips, newRing)
-		slog.Debug("Registered Hostname: " + host + " With Ip Address: " + ip.String())
+	stats := newRingStats(host, ips)
+	for index := 0; index < len(stats.Ips); index++ {
+		restoreIp(host, &stats.Ips[index])
 	}
 
+	ringHostsMu.Lock()
+	ringHosts[host] = stats
+	ringHostsMu.Unlock()
+
 	slog.Debug(" Done adding host: " + host)
-	ringCollector(host, 1, 1)
+	ringCollector(ctx, stats, 1, 1)
 	return nil
 }
 
+// Init the rings of each IP of the host, nothing is pinging them yet
+func newRingStats(host string, ips []net.IP) *RingStats {
+	stats := new(RingStats)
+	stats.Hostname = host
+
+	codec := config.GetCodecProfile(host)
+
+	for _, ip := range ips {
+		// Build the ring in place so we don't copy the mutex.
+		stats.Ips = append(stats.Ips, ipRings{
+			Ip:            ip,
+			Stats1k:       ring.New(1000),
+			Stats100:      ring.New(100),
+			Stats15:       ring.New(15),
+			Codec:         codec,
+			TimeWindows:   newTimeWindows(host),
+			latencyChange: newCusum(latencyWarmup, 0.1),
+			lossChange:    newCusum(lossWarmup, 0.01),
+		})
+		slog.Debug("Registered Hostname: " + host + " With Ip Address: " + ip.String())
+	}
+	return stats
+}
+
+// Return the rings for a host if we are monitoring it
+func GetRingHost(host string) (*RingStats, bool) {
+	ringHostsMu.RLock()
+	defer ringHostsMu.RUnlock()
+	rings, ok := ringHosts[host]
+	return rings, ok
+}
+
+// Return a copy of the hosts we are monitoring, safe to range over while hosts are being removed
+func MonitoredHosts() map[string]*RingStats {
+	ringHostsMu.RLock()
+	defer ringHostsMu.RUnlock()
+	hosts := make(map[string]*RingStats, len(ringHosts))
+	for host, rings := range ringHosts {
+		hosts[host] = rings
+	}
+	return hosts
+}
+
 /*
 Low Level ping thread, Takes seconds between runs and number of packets to send.
-Can be shutdown by writing (technically any value to the shutdown channel) runs
-forever until shutdown.
+Runs forever until ctx is cancelled, which happens on shutdown or when the host is removed.
 */
-func pingThread(pIp *ipRings, seconds int, packets int, host string) {
+func pingThread(ctx context.Context, pIp *ipRings, seconds int, packets int, host string) {
+	defer probes.Done()
 	ticker := time.NewTicker(time.Second * time.Duration(seconds))
-	for range ticker.C {
+	defer ticker.Stop()
+	for {
 		// Check for incoming shutdown and return if we get one.
 		select {
-		case <-pIp.shutdown:
-			slog.Info("thread shutdown for : " + host + " ---> " + pIp.Ip.String())
+		case <-ctx.Done():
+			slog.Debug("thread shutdown for : " + host + " ---> " + pIp.Ip.String())
 			return
-		default:
+		case <-ticker.C:
 		}
 		startTime := time.Now()
 		pinger, err := probing.NewPinger(pIp.Ip.String())
@@ -116,15 +195,27 @@ func pingThread(pIp *ipRings, seconds int, packets int, host string) {
 		}
 		pinger.Count = packets
 		pinger.Timeout = time.Second * time.Duration(config.Config.ProbeTimeout)
+		log := newProbeLog(pinger)
+		// Stop the pinger early if we are shutting down
+		stop := context.AfterFunc(ctx, pinger.Stop)
 		err = pinger.Run() // Blocks until finished.
+		stop()
 		if err != nil {
 			slog.Error(err.Error())
 			return
 		}
+		// Don't count the packets we didn't wait for as lost
+		if ctx.Err() != nil {
+			return
+		}
 
 		stats := pinger.Statistics()
 
-		ringParseStats(*stats, pIp, host, startTime)
+		pings, pending := ringParseStats(*stats, log, pIp, host, startTime)
+		for _, e := range pending {
+			events.Record(e)
+		}
+		publishProbe(host, pIp, pings)
 	}
 }
 
@@ -141,38 +232,80 @@ func ringMaintance(s probing.Statistics, pIp *ipRings, host string, startTime ti
 Func to kick off the pingThreads for the first time. Can be called directly from a future API
 For now only call with 1 packet and 1 second.
 */
-func ringCollector(host string, seconds int, packets int) {
+func ringCollector(ctx context.Context, rings *RingStats, seconds int, packets int) {
+	ctx, rings.cancel = context.WithCancel(ctx)
 	// Loop this way so we aren't copying the RingStats struct and can reference it directly
-	for index := 0; index < len(RingHosts[host].Ips); index++ {
-		go pingThread(&RingHosts[host].Ips[index], seconds, packets, host)
+	for index := 0; index < len(rings.Ips); index++ {
+		probes.Add(1)
+		rings.threads.Add(1)
+		go func(pIp *ipRings) {
+			defer rings.threads.Done()
+			pingThread(ctx, pIp, seconds, packets, rings.Hostname)
+		}(&rings.Ips[index])
 	}
 }
 
-// Todo: check the value is there in the first place
-func deleteHost(hostname string) error {
-	var hostRing RingStats
+// Stop the ping threads of the host and wait for them to finish
+func (rings *RingStats) stop() {
+	if rings.cancel != nil {
+		rings.cancel()
+	}
+	rings.threads.Wait()
+}
 
-	hostRing = *RingHosts[hostname]
+// Wait for all of the ping threads to stop after their context is cancelled
+func WaitProbes() {
+	probes.Wait()
+}
 
-	for x, _ := range hostRing.Ips {
-		hostRing.Ips[x].shutdown <- true
+/*
+Stop monitoring a host. The ping threads are stopped before the IPs are retired so the exporters
+never see a probe for a target after they have been told it's gone.
+*/
+func RemoveRingHost(hostname string) error {
+	ringHostsMu.Lock()
+	rings, ok := ringHosts[hostname]
+	delete(ringHosts, hostname)
+	ringHostsMu.Unlock()
+	if !ok {
+		return errors.New("host is not monitored: " + hostname)
 	}
 
+	rings.stop()
+	forgetOutages(hostname)
+	rings.retire(nil)
 	return nil
 }
 
+// Retire every IP of the rings that isn't in keep, the ping threads must already be stopped
+func (rings *RingStats) retire(keep map[string]bool) {
+	for index := 0; index < len(rings.Ips); index++ {
+		ip := rings.Ips[index].Ip.String()
+		if keep[ip] {
+			continue
+		}
+		slog.Info("Retired: " + rings.Hostname + " ---> " + ip)
+		retireTarget(Target{Hostname: rings.Hostname, Ip: ip})
+	}
+}
+
 /*
 Function to parse the ping stats from each pingThread.
 
 I am not sure I really need to be locking this technically this the only place where the each ipRings
 struct (that name seems bad now). But I will be reading this from outside this package so I think it
-won't hurt to lock the data struct when accessing it.
+won't hurt to lock the data struct when accessing it. Returns the packets that were added and the
+events to record after the lock is released, since the event subscribers can take their time.
 */
-func ringParseStats(s probing.Statistics, pIp *ipRings, hostname string, startTime time.Time) {
-	// Generate arrays of ping packets for storage long term
-	pingPackets, err := generatePingPackets(s, startTime)
-	if err != nil {
-		slog.Warn("unable to generate ping packets")
+func ringParseStats(s probing.Statistics, log *probeLog, pIp *ipRings, hostname string, startTime time.Time) ([]ping, []events.Event) {
+	// Generate arrays of ping packets for storage long term, the callbacks have the real sent times
+	pingPackets := log.pings()
+	if len(pingPackets) != s.PacketsSent {
+		var err error
+		pingPackets, err = generatePingPackets(s, startTime)
+		if err != nil {
+			slog.Warn("unable to generate ping packets")
+		}
 	}
 
 	pIp.Mu.Lock()
@@ -200,8 +333,14 @@ func ringParseStats(s probing.Statistics, pIp *ipRings, hostname string, startTi
 			slog.Warn(err.Error())
 			slog.Warn(" Host: " + hostname + " ---> 15 ring")
 		}
+		down := trackOutage(ping, pIp, hostname)
+		trackAvailability(ping, pIp, down)
+		trackFlapping(ping, pIp, hostname)
+		trackChanges(ping, pIp, hostname)
 	}
 
+	trackTimeWindows(pingPackets, pIp)
+
 	pIp.Packetloss15 = genPacketloss(pIp.Stats15)
 	pIp.Packetloss100 = genPacketloss(pIp.Stats100)
 	pIp.Packetloss1000 = genPacketloss(pIp.Stats1k)
@@ -222,9 +361,37 @@ func ringParseStats(s probing.Statistics, pIp *ipRings, hostname string, startTi
 	pIp.Min100LatencyNs = genMinLatency(pIp.Stats100)
 	pIp.Min1000LatencyNs = genMinLatency(pIp.Stats1k)
 
-	// Update the prometheus metrics
-	prometheusUpdateMetrics(hostname, pIp)
-	updatedHistogramMetrics(hostname, s)
+	pIp.Median1000LatencyNs = genMedianLatency(pIp.Stats1k)
+	trackBaseline(pIp, hostname, startTime)
+
+	pIp.LossBursts15 = genLossBursts(pIp.Stats15)
+	pIp.LossBursts100 = genLossBursts(pIp.Stats100)
+	pIp.LossBursts1000 = genLossBursts(pIp.Stats1k)
+
+	codec := getCodecProfile(pIp.Codec)
+	pIp.RFactor15, pIp.Mos15 = genVoipQuality(codec, pIp.Stats15, pIp.Avg15LatencyNs, pIp.Jitter15LatencyNs, pIp.Packetloss15, pIp.LossBursts15.burstRatio())
+	pIp.RFactor100, pIp.Mos100 = genVoipQuality(codec, pIp.Stats100, pIp.Avg100LatencyNs, pIp.Jitter100LatencyNs, pIp.Packetloss100, pIp.LossBursts100.burstRatio())
+	pIp.RFactor1000, pIp.Mos1000 = genVoipQuality(codec, pIp.Stats1k, pIp.Avg1000LatencyNs, pIp.Jitter1000LatencyNs, pIp.Packetloss1000, pIp.LossBursts1000.burstRatio())
+
+	return pingPackets, pIp.takeEvents()
+}
+
+/*
+Queue an event to be recorded once the ipRings lock is released, new events get their Id now so
+they can be updated later. Must be called with the lock held.
+*/
+func (pIp *ipRings) queueEvent(e *events.Event) {
+	if e.Id == 0 {
+		e.Id = events.NewId()
+	}
+	pIp.pending = append(pIp.pending, *e)
+}
+
+// Take the queued events in the order they happened. Must be called with the lock held.
+func (pIp *ipRings) takeEvents() []events.Event {
+	pending := pIp.pending
+	pIp.pending = nil
+	return pending
 }
 
 /*
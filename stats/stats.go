@@ -24,6 +24,8 @@ type ping struct {
 	received      time.Time
 	sent          time.Time
 	replyReceived bool
+	seq           int
+	ttl           int
 }
 
 /*
@@ -33,7 +35,7 @@ Package Init:
 func init() {
 	hostStats = make(map[string]*list.List)
 	Hosts = make(map[string]*hostLongTerm)
-	RingHosts = make(map[string]*RingStats)
+	ringHosts = make(map[string]*RingStats)
 }
 
 // Add a host to the longterm Stats
@@ -0,0 +1,175 @@
+package stats
+
+import (
+	"sort"
+	"time"
+)
+
+// Packet windows cover a number of packets and time windows a length of time
+const (
+	WindowPackets = "packets"
+	WindowTime    = "time"
+)
+
+/*
+Stats for a single packet or time window, used for the JSON API and the exporters. RFactor and Mos
+are 0 until the window has something to estimate them from.
+*/
+type WindowSummary struct {
+	Window       string        `json:"window"`
+	Type         string        `json:"type"`
+	Sent         int           `json:"sent,omitempty"`
+	Packetloss   float64       `json:"packetloss"`
+	AvgLatencyNs time.Duration `json:"avg_latency_ns"`
+	MaxLatencyNs time.Duration `json:"max_latency_ns"`
+	MinLatencyNs time.Duration `json:"min_latency_ns"`
+	MedianNs     time.Duration `json:"median_latency_ns,omitempty"`
+	JitterNs     time.Duration `json:"jitter_ns"`
+	RFactor      float64       `json:"rfactor"`
+	Mos          float64       `json:"mos"`
+	MaxBurst     int           `json:"loss_burst_max"`
+	MeanBurst    float64       `json:"loss_burst_mean"`
+	BurstCount   int           `json:"loss_burst_count"`
+	GilbertP     float64       `json:"gilbert_p"`
+	GilbertR     float64       `json:"gilbert_r"`
+}
+
+type IpSummary struct {
+	Ip              string          `json:"ip_address"`
+	Codec           string          `json:"codec"`
+	TotalSent       int             `json:"total_sent"`
+	TotalReceived   int             `json:"total_received"`
+	TotalLoss       int             `json:"total_loss"`
+	TotalDuplicates int             `json:"total_duplicates"`
+	Flapping        bool            `json:"flapping"`
+	StateChanges    int             `json:"state_changes"`
+	Baseline        BaselineSummary `json:"baseline"`
+	Windows         []WindowSummary `json:"windows"`
+}
+
+type BaselineSummary struct {
+	BaselineNs     time.Duration `json:"baseline_ns"`
+	DeviationScore float64       `json:"deviation_score"`
+	Anomaly        bool          `json:"anomaly"`
+}
+
+type HostSummary struct {
+	Hostname string      `json:"hostname"`
+	Ips      []IpSummary `json:"ips"`
+}
+
+/*
+Return a copy of the current stats for every host sorted by hostname. Each ipRings is locked
+while we copy it so we don't hand out a half updated window.
+*/
+func GetHostSummaries() []HostSummary {
+	var summaries []HostSummary
+	for host := range MonitoredHosts() {
+		summaries = append(summaries, GetHostSummary(host))
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Hostname < summaries[j].Hostname
+	})
+	return summaries
+}
+
+// Return a copy of the current stats for a single host
+func GetHostSummary(host string) HostSummary {
+	summary := HostSummary{Hostname: host}
+	rings, ok := GetRingHost(host)
+	if !ok {
+		return summary
+	}
+
+	for index := 0; index < len(rings.Ips); index++ {
+		summary.Ips = append(summary.Ips, rings.Ips[index].summary())
+	}
+	return summary
+}
+
+func (pIp *ipRings) summary() IpSummary {
+	pIp.Mu.Lock()
+	defer pIp.Mu.Unlock()
+
+	summary := IpSummary{
+		Ip:              pIp.Ip.String(),
+		Codec:           pIp.Codec,
+		TotalSent:       pIp.TotalSent,
+		TotalReceived:   pIp.TotalReceived,
+		TotalLoss:       pIp.TotalLoss,
+		TotalDuplicates: pIp.TotalDuplicates,
+		Flapping:        pIp.Flapping,
+		StateChanges:    pIp.StateChanges,
+		Baseline: BaselineSummary{
+			BaselineNs:     time.Duration(pIp.Baseline.BaselineNs),
+			DeviationScore: pIp.Baseline.DeviationScore,
+			Anomaly:        pIp.Baseline.Anomaly,
+		},
+		Windows: []WindowSummary{
+			{
+				Window:       "15",
+				Type:         WindowPackets,
+				Packetloss:   pIp.Packetloss15,
+				AvgLatencyNs: pIp.Avg15LatencyNs,
+				MaxLatencyNs: pIp.Max15LatencyNs,
+				MinLatencyNs: pIp.Min15LatencyNs,
+				JitterNs:     pIp.Jitter15LatencyNs,
+				RFactor:      pIp.RFactor15,
+				Mos:          pIp.Mos15,
+				MaxBurst:     pIp.LossBursts15.MaxBurst,
+				MeanBurst:    pIp.LossBursts15.MeanBurst,
+				BurstCount:   pIp.LossBursts15.BurstCount,
+				GilbertP:     pIp.LossBursts15.GilbertP,
+				GilbertR:     pIp.LossBursts15.GilbertR,
+			},
+			{
+				Window:       "100",
+				Type:         WindowPackets,
+				Packetloss:   pIp.Packetloss100,
+				AvgLatencyNs: pIp.Avg100LatencyNs,
+				MaxLatencyNs: pIp.Max100LatencyNs,
+				MinLatencyNs: pIp.Min100LatencyNs,
+				JitterNs:     pIp.Jitter100LatencyNs,
+				RFactor:      pIp.RFactor100,
+				Mos:          pIp.Mos100,
+				MaxBurst:     pIp.LossBursts100.MaxBurst,
+				MeanBurst:    pIp.LossBursts100.MeanBurst,
+				BurstCount:   pIp.LossBursts100.BurstCount,
+				GilbertP:     pIp.LossBursts100.GilbertP,
+				GilbertR:     pIp.LossBursts100.GilbertR,
+			},
+			{
+				Window:       "1000",
+				Type:         WindowPackets,
+				Packetloss:   pIp.Packetloss1000,
+				AvgLatencyNs: pIp.Avg1000LatencyNs,
+				MaxLatencyNs: pIp.Max1000LatencyNs,
+				MinLatencyNs: pIp.Min1000LatencyNs,
+				MedianNs:     pIp.Median1000LatencyNs,
+				JitterNs:     pIp.Jitter1000LatencyNs,
+				RFactor:      pIp.RFactor1000,
+				Mos:          pIp.Mos1000,
+				MaxBurst:     pIp.LossBursts1000.MaxBurst,
+				MeanBurst:    pIp.LossBursts1000.MeanBurst,
+				BurstCount:   pIp.LossBursts1000.BurstCount,
+				GilbertP:     pIp.LossBursts1000.GilbertP,
+				GilbertR:     pIp.LossBursts1000.GilbertR,
+			},
+		},
+	}
+
+	// Time windows don't have the VoIP or burst stats so those are left empty
+	for _, w := range pIp.TimeWindows {
+		summary.Windows = append(summary.Windows, WindowSummary{
+			Window:       w.Label,
+			Type:         WindowTime,
+			Sent:         w.Sent,
+			Packetloss:   w.Packetloss,
+			AvgLatencyNs: w.AvgLatencyNs,
+			MaxLatencyNs: w.MaxLatencyNs,
+			MinLatencyNs: w.MinLatencyNs,
+			JitterNs:     w.JitterLatencyNs,
+		})
+	}
+	return summary
+}
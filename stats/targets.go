@@ -0,0 +1,118 @@
+/*
+Targets coming and going while we run.
+
+A target is a hostname and one of the IPs it resolved to. When a host is removed or its DNS changes,
+the IPs that are gone are retired and the subscribers are told so the exporters can stop reporting
+their last values forever. IPs that are still resolved keep their windows across the change.
+*/
+package stats
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net"
+	"sync"
+	"time"
+)
+
+type Target struct {
+	Hostname string
+	Ip       string
+}
+
+var (
+	retiredMu          sync.Mutex
+	retiredSubscribers []func(Target)
+)
+
+// Register a function to be called when a target is retired.
+func SubscribeRetired(handler func(Target)) {
+	retiredMu.Lock()
+	defer retiredMu.Unlock()
+	retiredSubscribers = append(retiredSubscribers, handler)
+}
+
+func retireTarget(target Target) {
+	retiredMu.Lock()
+	handlers := retiredSubscribers
+	retiredMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(target)
+	}
+}
+
+/*
+Resolve the host again and if the addresses have changed replace it. The windows of the IPs that
+are still there are carried over to the new rings and the rest are retired.
+
+The new rings are swapped in under the lock in one go so the host never looks unmonitored, only then
+are the old ping threads stopped and the IPs that are really gone retired.
+*/
+func RefreshRingHost(ctx context.Context, host string) error {
+	rings, ok := GetRingHost(host)
+	if !ok {
+		return errors.New("host is not monitored: " + host)
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return err
+	}
+	resolved := make(map[string]bool)
+	for _, ip := range ips {
+		resolved[ip.String()] = true
+	}
+	current := make(map[string]bool)
+	previous := make(map[string]*ipRings)
+	for index := 0; index < len(rings.Ips); index++ {
+		current[rings.Ips[index].Ip.String()] = true
+		previous[rings.Ips[index].Ip.String()] = &rings.Ips[index]
+	}
+	if maps.Equal(resolved, current) {
+		return nil
+	}
+	slog.Info("Addresses changed for: " + host)
+
+	refreshed := newRingStats(host, ips)
+	for index := 0; index < len(refreshed.Ips); index++ {
+		if pIp, ok := previous[refreshed.Ips[index].Ip.String()]; ok {
+			applySnapshot(host, &refreshed.Ips[index], saveIp(pIp))
+		}
+	}
+
+	ringHostsMu.Lock()
+	if ringHosts[host] != rings {
+		// Removed or refreshed by someone else while we were resolving
+		ringHostsMu.Unlock()
+		return errors.New("host changed while refreshing: " + host)
+	}
+	ringHosts[host] = refreshed
+	ringHostsMu.Unlock()
+
+	rings.stop()
+	forgetOutages(host)
+	rings.retire(resolved)
+	ringCollector(ctx, refreshed, 1, 1)
+	return nil
+}
+
+// Check the DNS of every host each interval until ctx is cancelled.
+func DnsRefresher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for host := range MonitoredHosts() {
+			if err := RefreshRingHost(ctx, host); err != nil {
+				slog.Warn("Unable to refresh addresses for: " + host + " " + err.Error())
+			}
+		}
+	}
+}
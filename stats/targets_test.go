@@ -0,0 +1,62 @@
+package stats
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestRefreshRingHost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ips, err := net.LookupIP("localhost")
+	if err != nil || len(ips) == 0 {
+		t.Skip("localhost doesn't resolve")
+	}
+	kept := ips[0].String()
+	gone := "192.0.2.1"
+
+	old := newRingStats("localhost", []net.IP{net.ParseIP(gone), ips[0]})
+	old.Ips[1].TotalSent = 42
+	ringHostsMu.Lock()
+	ringHosts["localhost"] = old
+	ringHostsMu.Unlock()
+	defer RemoveRingHost("localhost")
+
+	var mu sync.Mutex
+	var retired []Target
+	SubscribeRetired(func(target Target) {
+		mu.Lock()
+		retired = append(retired, target)
+		mu.Unlock()
+	})
+
+	if err := RefreshRingHost(ctx, "localhost"); err != nil {
+		t.Fatal(err)
+	}
+
+	rings, ok := GetRingHost("localhost")
+	if !ok || rings == old {
+		t.Fatal("the new rings weren't swapped in")
+	}
+	var found bool
+	for index := 0; index < len(rings.Ips); index++ {
+		if rings.Ips[index].Ip.String() == kept {
+			found = true
+			if rings.Ips[index].TotalSent != 42 {
+				t.Errorf("kept IP has TotalSent %d, want 42", rings.Ips[index].TotalSent)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("kept IP %s is missing from the new rings", kept)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(retired) != 1 || retired[0] != (Target{Hostname: "localhost", Ip: gone}) {
+		t.Errorf("retired %v, want only %s", retired, gone)
+	}
+}
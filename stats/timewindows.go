@@ -0,0 +1,183 @@
+/*
+Time based windows (last 5m/1h/24h...) alongside the packet count windows.
+
+The packet windows cover a different amount of wall-clock time depending on how often we ping a
+host, so a 1000 packet window for a 200ms target isn't comparable to one for a 10s target. For
+hosts that have time windows configured each window is split into a fixed number of time buckets
+that keep running totals of the packets sent in them. A packet only touches the bucket it was sent
+in and the window stats are summed from the buckets, so a 24h window of a 200ms target costs the
+same as a 5m one. The window slides a bucket at a time, so it covers its length to within one
+bucket (24 minutes of a 24h window).
+*/
+package stats
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+)
+
+const timeWindowBuckets = 60
+
+type timeWindow struct {
+	Label           string
+	Length          time.Duration
+	Sent            int
+	Received        int
+	Packetloss      float64
+	AvgLatencyNs    time.Duration
+	MaxLatencyNs    time.Duration
+	MinLatencyNs    time.Duration
+	JitterLatencyNs time.Duration
+
+	width   time.Duration
+	buckets []timeBucket
+	newest  time.Time
+}
+
+// Running totals of the packets sent within one bucket of a window
+type timeBucket struct {
+	number    int64
+	sent      int
+	received  int
+	totalRtt  time.Duration
+	maxRtt    time.Duration
+	minRtt    time.Duration
+	totalDiff time.Duration
+	diffs     int
+	// The first and last replies so the jitter can carry across buckets
+	firstRtt time.Duration
+	lastRtt  time.Duration
+}
+
+// Build the time windows for a host from the config
+func newTimeWindows(host string) []timeWindow {
+	var windows []timeWindow
+	for _, label := range config.GetTimeWindows(host) {
+		length, err := time.ParseDuration(label)
+		if err != nil || length <= 0 {
+			slog.Warn("Invalid time window " + label + " for host: " + host)
+			continue
+		}
+		width := length / timeWindowBuckets
+		if width <= 0 {
+			width = 1
+		}
+		windows = append(windows, timeWindow{
+			Label:   label,
+			Length:  length,
+			width:   width,
+			buckets: make([]timeBucket, timeWindowBuckets),
+		})
+	}
+	return windows
+}
+
+/*
+Add the packets to the buckets of each time window and recalculate the window stats as of the
+newest packet we have seen. Must be called with the ipRings lock held.
+*/
+func trackTimeWindows(packets []ping, pIp *ipRings) {
+	if len(packets) == 0 {
+		return
+	}
+	for index := range pIp.TimeWindows {
+		window := &pIp.TimeWindows[index]
+		for _, p := range packets {
+			window.add(p)
+		}
+		window.update()
+	}
+}
+
+// Add a packet to the bucket it was sent in, packets older than the window are ignored
+func (w *timeWindow) add(p ping) {
+	if p.sent.After(w.newest) {
+		w.newest = p.sent
+	}
+	number := p.sent.UnixNano() / int64(w.width)
+	b := &w.buckets[number%int64(len(w.buckets))]
+	if b.number != number {
+		if b.number > number {
+			return
+		}
+		// The slot still has an old bucket in it, start over
+		*b = timeBucket{number: number}
+	}
+
+	b.sent++
+	if !p.replyReceived {
+		return
+	}
+	if b.received == 0 {
+		b.firstRtt = p.rtts
+		b.minRtt = p.rtts
+	} else {
+		b.totalDiff = b.totalDiff + time.Duration(absInt(int64(p.rtts-b.lastRtt)))
+		b.diffs++
+	}
+	b.received++
+	b.lastRtt = p.rtts
+	b.totalRtt = b.totalRtt + p.rtts
+	if p.rtts > b.maxRtt {
+		b.maxRtt = p.rtts
+	}
+	if p.rtts < b.minRtt {
+		b.minRtt = p.rtts
+	}
+}
+
+// Sum the buckets that are within the window as of the newest packet, oldest first
+func (w *timeWindow) update() {
+	var totalTime, totalDiff time.Duration
+	var last time.Duration
+	var diffs int
+
+	w.Sent = 0
+	w.Received = 0
+	w.MaxLatencyNs = 0
+	w.MinLatencyNs = 0
+
+	count := int64(len(w.buckets))
+	current := w.newest.UnixNano() / int64(w.width)
+	for number := current - count + 1; number <= current; number++ {
+		b := &w.buckets[((number%count)+count)%count]
+		if b.number != number {
+			continue
+		}
+		w.Sent = w.Sent + b.sent
+		if b.received == 0 {
+			continue
+		}
+		if w.Received != 0 {
+			totalDiff = totalDiff + time.Duration(absInt(int64(b.firstRtt-last)))
+			diffs++
+		}
+		w.Received = w.Received + b.received
+		totalTime = totalTime + b.totalRtt
+		totalDiff = totalDiff + b.totalDiff
+		diffs = diffs + b.diffs
+		last = b.lastRtt
+
+		if b.maxRtt > w.MaxLatencyNs {
+			w.MaxLatencyNs = b.maxRtt
+		}
+		if b.minRtt < w.MinLatencyNs || w.MinLatencyNs == 0 {
+			w.MinLatencyNs = b.minRtt
+		}
+	}
+
+	w.Packetloss = 0
+	if w.Sent != 0 {
+		w.Packetloss = float64(w.Sent-w.Received) / float64(w.Sent)
+	}
+	w.AvgLatencyNs = 0
+	if w.Received != 0 {
+		w.AvgLatencyNs = totalTime / time.Duration(w.Received)
+	}
+	w.JitterLatencyNs = 0
+	if diffs != 0 {
+		w.JitterLatencyNs = totalDiff / time.Duration(diffs)
+	}
+}
@@ -0,0 +1,89 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+// Work the stats out the slow way from every packet in the window
+func bruteTimeWindow(packets []ping, from time.Time, to time.Time) timeWindow {
+	var w timeWindow
+	var total, totalDiff, last time.Duration
+	var diffs int
+	for _, p := range packets {
+		if p.sent.Before(from) || p.sent.After(to) {
+			continue
+		}
+		w.Sent++
+		if !p.replyReceived {
+			continue
+		}
+		if w.Received != 0 {
+			totalDiff = totalDiff + time.Duration(absInt(int64(p.rtts-last)))
+			diffs++
+		}
+		w.Received++
+		total = total + p.rtts
+		last = p.rtts
+		if p.rtts > w.MaxLatencyNs {
+			w.MaxLatencyNs = p.rtts
+		}
+		if p.rtts < w.MinLatencyNs || w.MinLatencyNs == 0 {
+			w.MinLatencyNs = p.rtts
+		}
+	}
+	w.Packetloss = float64(w.Sent-w.Received) / float64(w.Sent)
+	w.AvgLatencyNs = total / time.Duration(w.Received)
+	w.JitterLatencyNs = totalDiff / time.Duration(diffs)
+	return w
+}
+
+func TestTimeWindows(t *testing.T) {
+	pIp := &ipRings{TimeWindows: []timeWindow{
+		{Label: "5m", Length: 5 * time.Minute, width: 5 * time.Minute / timeWindowBuckets, buckets: make([]timeBucket, timeWindowBuckets)},
+		{Label: "1h", Length: time.Hour, width: time.Hour / timeWindowBuckets, buckets: make([]timeBucket, timeWindowBuckets)},
+	}}
+
+	// 20 minutes of 200ms pings sent 5 at a time, every 7th is lost
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var all []ping
+	for i := 0; i < 20*60*5; i = i + 5 {
+		var probe []ping
+		for j := i; j < i+5; j++ {
+			p := ping{sent: start.Add(time.Duration(j) * 200 * time.Millisecond)}
+			if j%7 != 0 {
+				p.replyReceived = true
+				p.rtts = time.Duration(10+j%13) * time.Millisecond
+			}
+			probe = append(probe, p)
+		}
+		all = append(all, probe...)
+		trackTimeWindows(probe, pIp)
+	}
+	newest := all[len(all)-1].sent
+
+	for _, got := range pIp.TimeWindows {
+		// The window covers the buckets that end within its length of the newest packet
+		from := newest.Truncate(got.width).Add(-got.width * (timeWindowBuckets - 1))
+		want := bruteTimeWindow(all, from, newest)
+		if got.Sent != want.Sent || got.Received != want.Received || got.Packetloss != want.Packetloss ||
+			got.AvgLatencyNs != want.AvgLatencyNs || got.MaxLatencyNs != want.MaxLatencyNs ||
+			got.MinLatencyNs != want.MinLatencyNs || got.JitterLatencyNs != want.JitterLatencyNs {
+			t.Errorf("%s window got %+v\nwant %+v", got.Label, got, want)
+		}
+	}
+	if pIp.TimeWindows[0].Sent > 5*60*5 || pIp.TimeWindows[0].Sent < 5*60*5-5*5 {
+		t.Errorf("5m window has %d packets, want about 1500", pIp.TimeWindows[0].Sent)
+	}
+	// Only 20 minutes so far so the hour has everything
+	if pIp.TimeWindows[1].Sent != len(all) {
+		t.Errorf("1h window has %d packets, want %d", pIp.TimeWindows[1].Sent, len(all))
+	}
+
+	// A packet from before the window is ignored
+	before := pIp.TimeWindows[0]
+	trackTimeWindows([]ping{{sent: start}}, pIp)
+	if pIp.TimeWindows[0].Sent != before.Sent {
+		t.Errorf("an old packet changed the 5m window from %d to %d", before.Sent, pIp.TimeWindows[0].Sent)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Parent/child dependencies between hosts.
+
+Hosts can have a parent host (the router they sit behind). We keep track of which hosts are fully
+down (every IP in an outage) so that when a parent is down we can suppress the alerts for everything
+behind it and mark the child outages as caused by the parent. The outage state is kept here with its
+own lock so we never have to lock another host's ipRings while holding our own.
+*/
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+)
+
+// How far ahead of the parent a child outage can start and still be blamed on the parent
+const parentTolerance = 10 * time.Second
+
+type hostOutage struct {
+	Start time.Time
+	End   time.Time
+}
+
+type TopologyNode struct {
+	Hostname     string         `json:"hostname"`
+	Parent       string         `json:"parent,omitempty"`
+	InOutage     bool           `json:"in_outage"`
+	Suppressed   bool           `json:"suppressed"`
+	SuppressedBy string         `json:"suppressed_by,omitempty"`
+	Children     []TopologyNode `json:"children"`
+}
+
+var (
+	topologyMu  sync.Mutex
+	ipsInOutage map[string]int
+	hostOutages map[string]hostOutage
+)
+
+func init() {
+	ipsInOutage = make(map[string]int)
+	hostOutages = make(map[string]hostOutage)
+}
+
+// Record that an IP of a host has gone into an outage
+func markOutageStart(host string, start time.Time) {
+	topologyMu.Lock()
+	defer topologyMu.Unlock()
+
+	ipsInOutage[host]++
+	if rings, ok := GetRingHost(host); ok && ipsInOutage[host] == len(rings.Ips) {
+		hostOutages[host] = hostOutage{Start: start}
+	}
+}
+
+// Record that an IP of a host has come back
+func markOutageEnd(host string, end time.Time) {
+	topologyMu.Lock()
+	defer topologyMu.Unlock()
+
+	if rings, ok := GetRingHost(host); ok && ipsInOutage[host] == len(rings.Ips) {
+		outage := hostOutages[host]
+		outage.End = end
+		hostOutages[host] = outage
+	}
+	if ipsInOutage[host] > 0 {
+		ipsInOutage[host]--
+	}
+}
+
+// Drop the outage state of a host that is no longer monitored
+func forgetOutages(host string) {
+	topologyMu.Lock()
+	defer topologyMu.Unlock()
+	delete(ipsInOutage, host)
+	delete(hostOutages, host)
+}
+
+// Check if every IP of a host is in an outage
+func HostInOutage(host string) bool {
+	topologyMu.Lock()
+	defer topologyMu.Unlock()
+	return hostInOutage(host)
+}
+
+func hostInOutage(host string) bool {
+	rings, ok := GetRingHost(host)
+	return ok && len(rings.Ips) > 0 && ipsInOutage[host] == len(rings.Ips)
+}
+
+// Return the parent, grandparent... of a host stopping if the config has a loop
+func ancestors(host string) []string {
+	var list []string
+	seen := map[string]bool{host: true}
+	for {
+		parent, ok := config.GetParent(host)
+		if !ok || seen[parent] {
+			return list
+		}
+		list = append(list, parent)
+		seen[parent] = true
+		host = parent
+	}
+}
+
+// Return the closest ancestor of the host that is down, if there is one
+func Suppressed(host string) (string, bool) {
+	topologyMu.Lock()
+	defer topologyMu.Unlock()
+
+	for _, ancestor := range ancestors(host) {
+		if hostInOutage(ancestor) {
+			return ancestor, true
+		}
+	}
+	return "", false
+}
+
+/*
+Return the ancestor whose outage covers an outage of the host starting at start. Parents and
+children are probed independently so the child can notice first; that's what the tolerance is for.
+*/
+func causedBy(host string, start time.Time) string {
+	topologyMu.Lock()
+	defer topologyMu.Unlock()
+
+	for _, ancestor := range ancestors(host) {
+		outage, ok := hostOutages[ancestor]
+		if !ok || outage.Start.IsZero() {
+			continue
+		}
+		if start.Before(outage.Start.Add(-parentTolerance)) {
+			continue
+		}
+		if outage.End.IsZero() || !start.After(outage.End) {
+			return ancestor
+		}
+	}
+	return ""
+}
+
+// Return the dependency tree of the monitored hosts with their outage and suppression state
+func GetTopology() []TopologyNode {
+	hosts := MonitoredHosts()
+	children := make(map[string][]string)
+	var roots []string
+	for host := range hosts {
+		parent, ok := config.GetParent(host)
+		if _, monitored := hosts[parent]; ok && monitored && parent != host {
+			children[parent] = append(children[parent], host)
+		} else {
+			roots = append(roots, host)
+		}
+	}
+
+	seen := make(map[string]bool)
+	nodes := []TopologyNode{}
+	for _, root := range sortedHosts(roots) {
+		nodes = append(nodes, topologyNode(root, children, seen))
+	}
+
+	// Hosts in a parent loop never show up under a root so list them on their own
+	var loops []string
+	for host := range hosts {
+		if !seen[host] {
+			loops = append(loops, host)
+		}
+	}
+	for _, host := range sortedHosts(loops) {
+		if !seen[host] {
+			nodes = append(nodes, topologyNode(host, children, seen))
+		}
+	}
+	return nodes
+}
+
+func topologyNode(host string, children map[string][]string, seen map[string]bool) TopologyNode {
+	seen[host] = true
+	node := TopologyNode{Hostname: host, Children: []TopologyNode{}}
+	node.Parent, _ = config.GetParent(host)
+	node.InOutage = HostInOutage(host)
+	node.SuppressedBy, node.Suppressed = Suppressed(host)
+
+	for _, child := range sortedHosts(children[host]) {
+		if !seen[child] {
+			node.Children = append(node.Children, topologyNode(child, children, seen))
+		}
+	}
+	return node
+}
+
+func sortedHosts(hosts []string) []string {
+	sort.Strings(hosts)
+	return hosts
+}
@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+	"github.com/cheetahfox/longping/events"
+)
+
+func TestParentOutageSuppressesChild(t *testing.T) {
+	config.Config.OutageLosses = 3
+	config.Config.HostParents = map[string]string{"child.test": "router.test"}
+	t.Cleanup(func() { config.Config.HostParents = nil })
+
+	router := newRingStats("router.test", []net.IP{net.ParseIP("192.0.2.50")})
+	child := newRingStats("child.test", []net.IP{net.ParseIP("192.0.2.51")})
+	ringHostsMu.Lock()
+	ringHosts["router.test"] = router
+	ringHosts["child.test"] = child
+	ringHostsMu.Unlock()
+	t.Cleanup(func() {
+		ringHostsMu.Lock()
+		delete(ringHosts, "router.test")
+		delete(ringHosts, "child.test")
+		ringHostsMu.Unlock()
+		forgetOutages("router.test")
+		forgetOutages("child.test")
+	})
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	probe := func(pIp *ipRings, host string, seconds int, reply bool) []events.Event {
+		trackOutage(ping{sent: at(seconds), replyReceived: reply}, pIp, host)
+		return pIp.takeEvents()
+	}
+	routerIp, childIp := &router.Ips[0], &child.Ips[0]
+
+	// The router goes down, the child notices a second later
+	for i := 0; i < 3; i++ {
+		probe(routerIp, "router.test", i, false)
+	}
+	if !HostInOutage("router.test") {
+		t.Fatal("router isn't in an outage")
+	}
+	if parent, ok := Suppressed("child.test"); !ok || parent != "router.test" {
+		t.Errorf("child suppressed %v by %q, want suppressed by router.test", ok, parent)
+	}
+	if _, ok := Suppressed("router.test"); ok {
+		t.Error("router is suppressed")
+	}
+
+	var opened []events.Event
+	for i := 1; i < 4; i++ {
+		opened = append(opened, probe(childIp, "child.test", i, false)...)
+	}
+	if len(opened) != 1 || opened[0].CausedBy != "router.test" || !strings.Contains(opened[0].Message, "caused by parent router.test") {
+		t.Fatalf("got %+v, want a child outage caused by router.test", opened)
+	}
+	closed := probe(childIp, "child.test", 10, true)
+	if len(closed) != 1 || closed[0].CausedBy != "router.test" {
+		t.Errorf("got %+v, want the closed child outage caused by router.test", closed)
+	}
+
+	// Once the router is back the child is on its own again
+	probe(routerIp, "router.test", 10, true)
+	if HostInOutage("router.test") {
+		t.Error("router is still in an outage")
+	}
+	if parent, ok := Suppressed("child.test"); ok {
+		t.Errorf("child still suppressed by %q", parent)
+	}
+	var later []events.Event
+	for i := 100; i < 103; i++ {
+		later = append(later, probe(childIp, "child.test", i, false)...)
+	}
+	if len(later) != 1 || later[0].CausedBy != "" {
+		t.Errorf("got %+v, want a child outage with no cause", later)
+	}
+}
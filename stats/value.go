@@ -0,0 +1,46 @@
+package stats
+
+import (
+	"reflect"
+	"strings"
+)
+
+/*
+Look up one of the computed stats for an IP by its field name so things like the alert rules can
+refer to any of them without a big switch. Nested fields use a dot (Baseline.DeviationScore) and
+durations come back as nanoseconds.
+*/
+func (pIp *ipRings) Value(field string) (float64, bool) {
+	pIp.Mu.Lock()
+	defer pIp.Mu.Unlock()
+
+	v := reflect.ValueOf(pIp).Elem()
+	for _, name := range strings.Split(field, ".") {
+		if v.Kind() != reflect.Struct {
+			return 0, false
+		}
+		f, ok := v.Type().FieldByName(name)
+		if !ok || !f.IsExported() {
+			return 0, false
+		}
+		v = v.FieldByIndex(f.Index)
+	}
+
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Bool:
+		return boolToFloat(v.Bool()), true
+	}
+	return 0, false
+}
+
+// A true is a 1 so flags can be compared like any other value
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
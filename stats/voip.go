@@ -0,0 +1,102 @@
+/*
+VoIP quality estimation using the simplified ITU-T G.107 E-model.
+
+We don't have any real voice traffic to look at so this is an estimate based on the latency, jitter
+and packet loss of each packet window. The one-way delay is taken as half the RTT plus a jitter
+buffer sized at twice the jitter plus the codec's own packetization delay. The impairment values
+for each codec come from ITU-T G.113 Appendix I.
+*/
+package stats
+
+import (
+	"container/ring"
+	"math"
+	"time"
+)
+
+// Codec impairment settings used by the E-model
+type codecProfile struct {
+	Name  string
+	Ie    float64       // Equipment impairment factor
+	Bpl   float64       // Packet-loss robustness factor
+	Delay time.Duration // Frame size plus look ahead
+}
+
+var codecProfiles = map[string]codecProfile{
+	"g711":   {Name: "g711", Ie: 0, Bpl: 25.1, Delay: 20 * time.Millisecond},
+	"g729":   {Name: "g729", Ie: 11, Bpl: 19.0, Delay: 25 * time.Millisecond},
+	"g723.1": {Name: "g723.1", Ie: 15, Bpl: 16.1, Delay: 67500 * time.Microsecond},
+	"ilbc":   {Name: "ilbc", Ie: 11, Bpl: 32, Delay: 25 * time.Millisecond},
+}
+
+// Look up a codec profile by name. The config only lets known codecs through, anything else is g711
+func getCodecProfile(name string) codecProfile {
+	codec, ok := codecProfiles[name]
+	if !ok {
+		return codecProfiles["g711"]
+	}
+	return codec
+}
+
+/*
+The R-factor and MOS of a packet window, both are 0 when the window doesn't have anything to go on
+yet. A window that isn't full and hasn't had a reply would otherwise come out as a perfect link
+since the latency is 0 and the loss is worked out over the whole ring.
+*/
+func genVoipQuality(codec codecProfile, window *ring.Ring, avgLatency time.Duration, jitter time.Duration, packetloss float64, burstRatio float64) (float64, float64) {
+	if !voipReady(window) {
+		return 0, 0
+	}
+	r := genRFactor(codec, avgLatency, jitter, packetloss, burstRatio)
+	return r, genMos(r)
+}
+
+// A window is ready once it has had a reply or is full, a full window of losses really is 100% loss
+func voipReady(window *ring.Ring) bool {
+	full := true
+	ready := false
+	window.Do(func(v any) {
+		p, ok := v.(ping)
+		if !ok {
+			full = false
+			return
+		}
+		if p.replyReceived {
+			ready = true
+		}
+	})
+	return ready || full
+}
+
+/*
+Calculate the R-factor for a window. Packetloss is stored as 1 = 100% loss like the rest of
+the stats, the E-model wants it as a percentage. burstRatio is 1 for random loss.
+*/
+func genRFactor(codec codecProfile, avgLatency time.Duration, jitter time.Duration, packetloss float64, burstRatio float64) float64 {
+	// One-way delay in ms
+	d := float64(avgLatency/2+jitter*2+codec.Delay) / float64(time.Millisecond)
+
+	// Delay impairment
+	id := 0.024 * d
+	if d > 177.3 {
+		id = id + 0.11*(d-177.3)
+	}
+
+	// Effective equipment impairment including the packet loss
+	ppl := packetloss * 100
+	ieEff := codec.Ie + (95-codec.Ie)*(ppl/(ppl/burstRatio+codec.Bpl))
+
+	r := 93.2 - id - ieEff
+	return math.Max(0, math.Min(100, r))
+}
+
+// Convert a R-factor into a MOS score between 1 and 4.5
+func genMos(r float64) float64 {
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	return math.Max(1, 1+0.035*r+r*(r-60)*(100-r)*7e-6)
+}
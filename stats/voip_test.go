@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"container/ring"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cheetahfox/longping/config"
+)
+
+// The config only lets through the codecs we have a profile for
+func TestCodecProfilesMatchConfig(t *testing.T) {
+	if len(codecProfiles) != len(config.Codecs) {
+		t.Errorf("%d codec profiles but config knows %d codecs", len(codecProfiles), len(config.Codecs))
+	}
+	for _, name := range config.Codecs {
+		if _, ok := codecProfiles[name]; !ok {
+			t.Errorf("no codec profile for %s", name)
+		}
+	}
+}
+
+func TestGenRFactor(t *testing.T) {
+	g711 := codecProfiles["g711"]
+	g729 := codecProfiles["g729"]
+	tests := []struct {
+		name       string
+		codec      codecProfile
+		latency    time.Duration
+		jitter     time.Duration
+		loss       float64
+		burstRatio float64
+		want       float64
+	}{
+		// Only the codec delay, 93.2 - 0.024*20
+		{"no loss", g711, 0, 0, 0, 1, 92.72},
+		{"no loss g729", g729, 0, 0, 0, 1, 93.2 - 0.024*25 - 11},
+		// 20ms RTT and 2ms jitter is 10 + 4 + 20 = 34ms one way
+		{"lan", g711, 20 * time.Millisecond, 2 * time.Millisecond, 0, 1, 93.2 - 0.024*34},
+		// Past 177.3ms the delay impairment gets steeper
+		{"satellite", g711, 400 * time.Millisecond, 0, 0, 1, 93.2 - 0.024*220 - 0.11*(220-177.3)},
+		{"1% random loss", g711, 0, 0, 0.01, 1, 92.72 - 95*1/(1+25.1)},
+		{"10% random loss", g711, 0, 0, 0.1, 1, 92.72 - 95*10/(10+25.1)},
+		// The same loss in bursts hurts more
+		{"10% bursty loss", g711, 0, 0, 0.1, 4, 92.72 - 95*10/(10.0/4+25.1)},
+		{"100% loss", g711, 0, 0, 1, 1, 92.72 - 95*100/(100+25.1)},
+		{"100% loss g729", g729, 0, 0, 1, 1, 93.2 - 0.024*25 - 11 - 84.0*100/(100+19)},
+	}
+	for _, test := range tests {
+		if got := genRFactor(test.codec, test.latency, test.jitter, test.loss, test.burstRatio); math.Abs(got-test.want) > 0.001 {
+			t.Errorf("%s: R = %.3f, want %.3f", test.name, got, test.want)
+		}
+	}
+	if bursty, random := genRFactor(g711, 0, 0, 0.1, 4), genRFactor(g711, 0, 0, 0.1, 1); bursty >= random {
+		t.Errorf("bursty loss R %.2f isn't below random loss R %.2f", bursty, random)
+	}
+}
+
+// The R to MOS mapping from G.107 Annex B
+func TestGenMos(t *testing.T) {
+	tests := []struct {
+		r    float64
+		want float64
+	}{
+		{-5, 1},
+		{0, 1},
+		{50, 2.58},
+		{60, 3.10},
+		{70, 3.60},
+		{80, 4.02},
+		{90, 4.34},
+		{93.2, 4.41},
+		{100, 4.5},
+	}
+	for _, test := range tests {
+		if got := genMos(test.r); math.Abs(got-test.want) > 0.01 {
+			t.Errorf("MOS for R %v = %.3f, want %.2f", test.r, got, test.want)
+		}
+	}
+}
+
+func TestGenVoipQualityEmptyWindow(t *testing.T) {
+	g711 := codecProfiles["g711"]
+	fill := func(size int, packets []ping) *ring.Ring {
+		r := ring.New(size)
+		for _, p := range packets {
+			r.Value = p
+			r = r.Next()
+		}
+		return r
+	}
+	lost := ping{}
+	reply := ping{replyReceived: true, rtts: 20 * time.Millisecond}
+
+	tests := []struct {
+		name  string
+		ring  *ring.Ring
+		ready bool
+	}{
+		{"empty", fill(15, nil), false},
+		{"only losses so far", fill(15, []ping{lost, lost}), false},
+		{"a reply", fill(15, []ping{lost, reply}), true},
+		{"full of losses", fill(3, []ping{lost, lost, lost}), true},
+	}
+	for _, test := range tests {
+		r, mos := genVoipQuality(g711, test.ring, 0, 0, genPacketloss(test.ring), 1)
+		if test.ready && (mos < 1 || r == 0 && mos != 1) {
+			t.Errorf("%s: R %v MOS %v, want an estimate", test.name, r, mos)
+		}
+		if !test.ready && (r != 0 || mos != 0) {
+			t.Errorf("%s: R %v MOS %v, want no estimate", test.name, r, mos)
+		}
+	}
+	// A full window of losses is as bad as it gets
+	if _, mos := genVoipQuality(g711, fill(3, []ping{lost, lost, lost}), 0, 0, 1, 1); mos > 1.5 {
+		t.Errorf("MOS %v for 100%% loss", mos)
+	}
+}